@@ -6,19 +6,66 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
+// FeedMeta is what a feed tells about itself.
+type FeedMeta struct {
+	Title       string
+	Description string
+	SiteURL     string
+	Language    string
+	Icon        string
+	Generator   string
+}
+
 type Feed struct {
-	ID          int
+	ID int
+	// Name is set by the user and overrides the title of the feed.
+	Name string
+	// Host is the scheme and host of FeedURL.
 	Host        string
 	FeedURL     string
 	LastChecked sql.NullTime
 	LastUpdated sql.NullTime
+	// LastError is the error of the last fetch, if it failed.
+	LastError string
+	// Skipped is the number of unusable items in the last fetch.
+	Skipped int
+	// Disabled feeds are not fetched by scheduled updates,
+	// for example because the server reported them as gone.
+	Disabled bool
+	// Options are the sealed fetch options of the feed, see package secret.
+	Options string
+	FeedMeta
+}
+
+// DisplayName returns the name set by the user, the title of the feed
+// or the host, whichever is set first.
+func (f Feed) DisplayName() string {
+	switch {
+	case f.Name != "":
+		return f.Name
+	case f.Title != "":
+		return f.Title
+	default:
+		return f.Host
+	}
+}
+
+// Site returns the URL of the website of the feed.
+func (f Feed) Site() string {
+	if f.SiteURL != "" {
+		return f.SiteURL
+	}
+	return f.Host
 }
 
 const (
@@ -27,7 +74,23 @@ const (
 	fFeedURL     = 2
 	fLastChecked = 3
 	fLastUpdated = 4
-	fLen         = 5
+	fLastError   = 5
+	fName        = 6
+	fTitle       = 7
+	fDescription = 8
+	fSiteURL     = 9
+	fLanguage    = 10
+	fIcon        = 11
+	fGenerator   = 12
+	fSkipped     = 13
+	fDisabled    = 14
+	fOptions     = 15
+	fLen         = 16
+
+	// Rows written before the last error was stored only have 5 columns.
+	fMinLen = 5
+	// Rows without a name column stored the name in the host column.
+	fNoNameLen = 6
 )
 
 func feedsToRecs(feeds ...Feed) [][]string {
@@ -46,34 +109,73 @@ func feedsToRecs(feeds ...Feed) [][]string {
 		r[fFeedURL] = f.FeedURL
 		r[fLastChecked] = date(f.LastChecked)
 		r[fLastUpdated] = date(f.LastUpdated)
+		r[fLastError] = f.LastError
+		r[fName] = f.Name
+		r[fTitle] = f.Title
+		r[fDescription] = f.Description
+		r[fSiteURL] = f.SiteURL
+		r[fLanguage] = f.Language
+		r[fIcon] = f.Icon
+		r[fGenerator] = f.Generator
+		r[fSkipped] = strconv.Itoa(f.Skipped)
+		r[fDisabled] = strconv.FormatBool(f.Disabled)
+		r[fOptions] = f.Options
 		recs = append(recs, r)
 	}
 	return recs
 }
 
 type Item struct {
+	ID     int
 	FeedID int
-	Title  string
-	URL    string
-	Added  time.Time
+	// GUID is the RSS guid or Atom id, if the feed provides one.
+	GUID  string
+	Title string
+	URL   string
+	Added time.Time
+	// Digest changes if the entry is edited in the feed.
+	Digest  string
+	Read    bool
+	Starred bool
+	// Edited is set if the entry changed after it was added.
+	Edited bool
+	// Tags are set by rules when the item is added.
+	Tags []string
 }
 
 const (
-	iFeedID = 0
-	iTitle  = 1
-	iURL    = 2
-	iAdded  = 3
-	iLen    = 4
+	iFeedID  = 0
+	iTitle   = 1
+	iURL     = 2
+	iAdded   = 3
+	iID      = 4
+	iRead    = 5
+	iStarred = 6
+	iGUID    = 7
+	iDigest  = 8
+	iEdited  = 9
+	iTags    = 10
+	iLen     = 11
+
+	// Rows written before items had an ID only have the first 4 columns.
+	iMinLen = 4
 )
 
-func itemsToRecs(items ...Item) [][]string {
+func itemsToRecs(items ...*Item) [][]string {
 	recs := make([][]string, 0)
 	for _, item := range items {
 		r := make([]string, iLen)
+		r[iID] = strconv.Itoa(item.ID)
 		r[iFeedID] = strconv.Itoa(item.FeedID)
 		r[iTitle] = item.Title
 		r[iURL] = item.URL
 		r[iAdded] = item.Added.Format(timeFormat)
+		r[iRead] = strconv.FormatBool(item.Read)
+		r[iStarred] = strconv.FormatBool(item.Starred)
+		r[iGUID] = item.GUID
+		r[iDigest] = item.Digest
+		r[iEdited] = strconv.FormatBool(item.Edited)
+		r[iTags] = strings.Join(item.Tags, ",")
 		recs = append(recs, r)
 	}
 	return recs
@@ -81,141 +183,301 @@ func itemsToRecs(items ...Item) [][]string {
 
 type ItemWithHost struct {
 	Item
-	Host string
+	Host     string
+	FeedName string
 }
 
 type DB struct {
 	mu sync.RWMutex
 
+	// FlagEdited marks items as edited and unread again if their
+	// entry changes in the feed. Set it before using the DB.
+	FlagEdited bool
+
 	ctr      *os.File
 	csvFeeds *os.File
 	csvItems *os.File
+	csvSeen  *os.File
+	csvRules *os.File
+	lockFile *os.File
+	// loaded are the files as this process last loaded or wrote them.
+	loaded map[string]os.FileInfo
 
-	feeds []Feed
-	items []Item
+	feeds      []Feed
+	items      itemList
+	byID       map[int]*Item
+	byFeed     map[int]*feedItems
+	nextItemID int
+	// itemRows is the number of rows in the items file. Changed items
+	// are appended, so it can be larger than the number of items.
+	itemRows int
+	seen     map[seenKey]time.Time
+	// seenRows is the number of rows in the seen file, like itemRows.
+	seenRows int
+	rules    []Rule
 }
 
 const timeFormat = time.RFC3339
 
-func Open(ctrPath, feedsPath, itemsPath string) (*DB, error) {
-	open := func(path string) (*os.File, error) {
-		return os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_SYNC, 0644)
+// compactRows is how many rows of changed items the items file may hold
+// in addition to twice the number of items before it is rewritten.
+const compactRows = 1024
+
+// Files in the directory passed to Open.
+const (
+	CtrFile   = "ctr.csv"
+	FeedsFile = "feeds.csv"
+	ItemsFile = "items.csv"
+	SeenFile  = "seen.csv"
+	RulesFile = "rules.csv"
+	// LockFile is locked while a process writes the other files,
+	// so several processes can share the directory.
+	LockFile = "lock"
+)
+
+// Open loads the database stored in dir, creating missing files.
+func Open(dir string) (*DB, error) {
+	files := make([]*os.File, 0)
+	closeAll := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	for _, name := range []string{CtrFile, FeedsFile, ItemsFile, SeenFile, RulesFile, LockFile} {
+		f, err := os.OpenFile(filepath.Join(dir, name), os.O_RDWR|os.O_CREATE|os.O_SYNC, 0644)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	db := &DB{
+		ctr:      files[0],
+		csvFeeds: files[1],
+		csvItems: files[2],
+		csvSeen:  files[3],
+		csvRules: files[4],
+		lockFile: files[5],
+		loaded:   make(map[string]os.FileInfo),
 	}
 
-	ctr, err := open(ctrPath)
-	if err != nil {
+	// Locking loads the files.
+	if err := db.lock(); err != nil {
+		db.Close()
 		return nil, err
 	}
-	f, err := open(feedsPath)
+	fi, err := db.ctr.Stat()
+	if err == nil && fi.Size() == 0 {
+		_, err = db.ctr.Write([]byte("1"))
+	}
+	db.unlock()
 	if err != nil {
-		ctr.Close()
+		db.Close()
 		return nil, err
 	}
-	f2, err := open(itemsPath)
+	return db, nil
+}
+
+func parseDate(s string) (sql.NullTime, error) {
+	if s == "" {
+		return sql.NullTime{}, nil
+	}
+	t, err := time.Parse(timeFormat, s)
 	if err != nil {
-		ctr.Close()
-		f.Close()
-		return nil, err
+		return sql.NullTime{}, err
 	}
-	db := &DB{ctr: ctr, csvFeeds: f, csvItems: f2}
+	return sql.NullTime{
+		Valid: true,
+		Time:  t,
+	}, nil
+}
+
+func (db *DB) loadFeeds() error {
+	if _, err := db.csvFeeds.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	recs, err := csv.NewReader(db.csvFeeds).ReadAll()
+	if err != nil {
+		return err
+	}
+	feeds := make([]Feed, 0, len(recs))
+	for _, r := range recs {
+		if len(r) < fMinLen || len(r) > fLen {
+			return errors.New("feeds: unexpected row length")
+		}
+		noName := len(r) <= fNoNameLen
+		r = append(r, make([]string, fLen-len(r))...)
+
+		feed := Feed{
+			Name:      r[fName],
+			Host:      r[fHost],
+			FeedURL:   r[fFeedURL],
+			LastError: r[fLastError],
+			Options:   r[fOptions],
+			FeedMeta: FeedMeta{
+				Title:       r[fTitle],
+				Description: r[fDescription],
+				SiteURL:     r[fSiteURL],
+				Language:    r[fLanguage],
+				Icon:        r[fIcon],
+				Generator:   r[fGenerator],
+			},
+		}
+		if noName && !strings.Contains(feed.Host, "://") {
+			feed.Name = feed.Host
+			feed.Host = HostOf(feed.FeedURL)
+		}
 
-	err = func() error {
-		date := func(s string) (sql.NullTime, error) {
-			if s == "" {
-				return sql.NullTime{}, nil
+		feed.ID, err = strconv.Atoi(r[fID])
+		if err != nil {
+			return err
+		}
+		if r[fSkipped] != "" {
+			feed.Skipped, err = strconv.Atoi(r[fSkipped])
+			if err != nil {
+				return err
 			}
-			t, err := time.Parse(timeFormat, s)
+		}
+		if r[fDisabled] != "" {
+			feed.Disabled, err = strconv.ParseBool(r[fDisabled])
 			if err != nil {
-				return sql.NullTime{}, err
+				return err
 			}
-			return sql.NullTime{
-				Valid: true,
-				Time:  t,
-			}, nil
 		}
 
-		fi, err := ctr.Stat()
+		feed.LastChecked, err = parseDate(r[fLastChecked])
 		if err != nil {
 			return err
 		}
-		if fi.Size() == 0 {
-			_, err := ctr.Write([]byte("1"))
+		feed.LastUpdated, err = parseDate(r[fLastUpdated])
+		if err != nil {
 			return err
 		}
 
-		recs, err := csv.NewReader(f).ReadAll()
+		feeds = append(feeds, feed)
+	}
+	db.feeds = feeds
+	return nil
+}
+
+func (db *DB) loadItems(offset int64) error {
+	if _, err := db.csvItems.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	recs, err := csv.NewReader(db.csvItems).ReadAll()
+	if err != nil {
+		return err
+	}
+	if offset == 0 {
+		db.nextItemID = 1
+	}
+	migrate := false
+	items := make([]*Item, 0, len(recs))
+	// Later rows of an item replace the earlier ones.
+	rows := make(map[int]int)
+	for _, r := range recs {
+		if len(r) < iMinLen || len(r) > iLen {
+			return errors.New("items: unexpected row length")
+		}
+		if len(r) < iLen {
+			migrate = true
+			r = append(r, make([]string, iLen-len(r))...)
+		}
+
+		item := Item{
+			GUID:   r[iGUID],
+			Title:  r[iTitle],
+			URL:    r[iURL],
+			Digest: r[iDigest],
+		}
+
+		item.FeedID, err = strconv.Atoi(r[iFeedID])
 		if err != nil {
 			return err
 		}
-		for _, r := range recs {
-			if len(r) != fLen {
-				return errors.New("feeds: unexpected row length")
-			}
-
-			feed := Feed{
-				Host:    r[fHost],
-				FeedURL: r[fFeedURL],
-			}
 
-			feed.ID, err = strconv.Atoi(r[fID])
-			if err != nil {
-				return err
-			}
+		item.Added, err = time.Parse(timeFormat, r[iAdded])
+		if err != nil {
+			return err
+		}
 
-			feed.LastChecked, err = date(r[fLastChecked])
+		if r[iID] != "" {
+			item.ID, err = strconv.Atoi(r[iID])
 			if err != nil {
 				return err
 			}
-			feed.LastUpdated, err = date(r[fLastUpdated])
+		}
+		if r[iRead] != "" {
+			item.Read, err = strconv.ParseBool(r[iRead])
 			if err != nil {
 				return err
 			}
-
-			db.feeds = append(db.feeds, feed)
 		}
-
-		recs, err = csv.NewReader(f2).ReadAll()
-		if err != nil {
-			return err
-		}
-		for _, r := range recs {
-			if len(r) != iLen {
-				return errors.New("items: unexpected row length")
-			}
-
-			item := Item{
-				Title: r[iTitle],
-				URL:   r[iURL],
-			}
-
-			item.FeedID, err = strconv.Atoi(r[iFeedID])
+		if r[iStarred] != "" {
+			item.Starred, err = strconv.ParseBool(r[iStarred])
 			if err != nil {
 				return err
 			}
-
-			item.Added, err = time.Parse(timeFormat, r[iAdded])
+		}
+		if r[iEdited] != "" {
+			item.Edited, err = strconv.ParseBool(r[iEdited])
 			if err != nil {
 				return err
 			}
+		}
+		if r[iTags] != "" {
+			item.Tags = strings.Split(r[iTags], ",")
+		}
+		if item.ID >= db.nextItemID {
+			db.nextItemID = item.ID + 1
+		}
 
-			db.items = append(db.items, item)
+		if i, ok := rows[item.ID]; ok && item.ID != 0 {
+			items[i] = &item
+			continue
 		}
+		rows[item.ID] = len(items)
+		items = append(items, &item)
+	}
 
+	if offset > 0 {
+		for _, item := range items {
+			if stored, ok := db.byID[item.ID]; ok {
+				db.replaceItem(stored, *item)
+			} else {
+				db.indexItem(item)
+			}
+		}
+		db.itemRows += len(recs)
 		return nil
-	}()
-	if err != nil {
-		ctr.Close()
-		f.Close()
-		f2.Close()
-		return nil, err
 	}
 
-	return db, nil
+	if migrate {
+		for _, item := range items {
+			if item.ID == 0 {
+				item.ID = db.nextItemID
+				db.nextItemID++
+			}
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return itemLess(items[i], items[j])
+	})
+	db.reindex(items)
+	db.itemRows = len(recs)
+	if migrate || db.itemRows > 2*len(items)+compactRows {
+		return db.rewriteItems()
+	}
+	return nil
 }
 
+// Close waits for a running write to finish and closes the files.
 func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
 	var outer error
-	for _, c := range []io.Closer{db.ctr, db.csvFeeds, db.csvItems} {
+	for _, c := range []io.Closer{db.ctr, db.csvFeeds, db.csvItems, db.csvSeen, db.csvRules, db.lockFile} {
 		if err := c.Close(); err != nil {
 			outer = err
 		}
@@ -226,8 +488,10 @@ func (db *DB) Close() error {
 var ErrFound = errors.New("feed already exists in the database")
 
 func (db *DB) AddFeed(host, feedURL string) (int, error) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	if err := db.lock(); err != nil {
+		return -1, err
+	}
+	defer db.unlock()
 
 	for _, f := range db.feeds {
 		if f.FeedURL == feedURL {
@@ -254,9 +518,33 @@ func (db *DB) AddFeed(host, feedURL string) (int, error) {
 
 var timeNow = time.Now
 
-func (db *DB) AddItems(feedID int, items []Item) error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+// AddItems stores the items of the feed that are not yet known
+// and returns how many were added.
+func (db *DB) AddItems(feedID int, items []Item) (int, error) {
+	added, err := db.addItems(feedID, items)
+	return len(added), err
+}
+
+// AddNewItems is AddItems, but returns the added items.
+func (db *DB) AddNewItems(feedID int, items []Item) ([]Item, error) {
+	added, err := db.addItems(feedID, items)
+	if err != nil {
+		return nil, err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	out := make([]Item, 0, len(added))
+	for _, item := range added {
+		out = append(out, *item)
+	}
+	return out, nil
+}
+
+func (db *DB) addItems(feedID int, items []Item) ([]*Item, error) {
+	if err := db.lock(); err != nil {
+		return nil, err
+	}
+	defer db.unlock()
 
 	now := sql.NullTime{
 		Valid: true,
@@ -267,67 +555,236 @@ func (db *DB) AddItems(feedID int, items []Item) error {
 		if f.ID == feedID {
 			idx = i
 			db.feeds[idx].LastChecked = now
+			db.feeds[idx].LastError = ""
 			break
 		}
 	}
 	if idx < 0 {
-		return fmt.Errorf("unknown feed id %d", feedID)
+		return nil, fmt.Errorf("unknown feed id %d", feedID)
 	}
 
-	known := make(map[int]struct{})
-	for _, dbItem := range db.items {
-		for i, item := range items {
-			if dbItem.URL == item.URL {
-				known[i] = struct{}{}
+	firstID := db.nextItemID
+	added := make([]*Item, 0)
+	edited := make([]*Item, 0)
+	seen := make([]seenKey, 0)
+	for _, item := range items {
+		if stored, ok := db.lookup(feedID, item); ok {
+			// Duplicates within items are found as well.
+			if db.updateItem(stored, item) && stored.ID < firstID {
+				edited = append(edited, stored)
 			}
+			continue
 		}
-	}
 
-	add := make([]Item, 0)
-	for i, item := range items {
-		if _, ok := known[i]; !ok {
-			item.FeedID = feedID
-			add = append(add, item)
+		key := seenKey{feedID: feedID, key: item.identity()}
+		if _, ok := db.seen[key]; ok {
+			db.seen[key] = now.Time
+			seen = append(seen, key)
+			continue
 		}
+
+		add := item
+		add.ID = db.nextItemID
+		db.nextItemID++
+		add.FeedID = feedID
+		db.indexItem(&add)
+		added = append(added, &add)
 	}
 
-	if len(add) > 0 {
-		if err := insert(db.csvItems, itemsToRecs(add...)); err != nil {
-			return err
-		}
-		db.items = append(db.items, add...)
+	if err := db.writeItems(append(edited, added...)...); err != nil {
+		db.removeItems(func(item *Item) bool {
+			return item.ID >= firstID
+		})
+		return nil, err
+	}
+	if len(added) > 0 {
 		db.feeds[idx].LastUpdated = now
 	}
+	if err := db.writeSeen(seen...); err != nil {
+		return added, err
+	}
 
-	return rewrite(db.csvFeeds, feedsToRecs(db.feeds...))
+	return added, rewrite(&db.csvFeeds, feedsToRecs(db.feeds...))
+}
+
+// updateItem applies changes of the entry in the feed to a stored item
+// and reports whether it changed.
+func (db *DB) updateItem(stored *Item, item Item) bool {
+	if item.Digest == "" || item.Digest == stored.Digest {
+		return false
+	}
+	if stored.Digest != "" && db.FlagEdited {
+		stored.Edited = true
+		stored.Read = false
+	}
+	fi := db.byFeed[stored.FeedID]
+	if stored.GUID == "" && item.GUID != "" {
+		stored.GUID = item.GUID
+		fi.byGUID[stored.GUID] = stored
+	}
+	if stored.URL != item.URL {
+		if old := normalizeURL(stored.URL); fi.byURL[old] == stored {
+			delete(fi.byURL, old)
+		}
+		fi.byURL[normalizeURL(item.URL)] = stored
+	}
+	stored.Title = item.Title
+	stored.URL = item.URL
+	stored.Digest = item.Digest
+	return true
 }
 
 func (db *DB) AllFeeds() ([]Feed, error) {
-	less := func(i, j int) bool {
+	db.mu.RLock()
+	feeds := make([]Feed, len(db.feeds))
+	copy(feeds, db.feeds)
+	db.mu.RUnlock()
+
+	sort.SliceStable(feeds, func(i, j int) bool {
 		// TODO: check valid
-		return db.feeds[i].LastUpdated.Time.After(db.feeds[j].LastUpdated.Time)
+		return feeds[i].LastUpdated.Time.After(feeds[j].LastUpdated.Time)
+	})
+	return feeds, nil
+}
+
+// Feed returns the feed with the id or sql.ErrNoRows.
+func (db *DB) Feed(id int) (Feed, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	for _, f := range db.feeds {
+		if f.ID == id {
+			return f, nil
+		}
 	}
+	return Feed{}, sql.ErrNoRows
+}
 
-	db.mu.Lock()
-	defer db.mu.Unlock()
+// SetFeedError records that fetching the feed failed.
+func (db *DB) SetFeedError(id int, fetchErr error) error {
+	if err := db.lock(); err != nil {
+		return err
+	}
+	defer db.unlock()
 
-	if !sort.SliceIsSorted(db.feeds, less) {
-		sort.Slice(db.feeds, less)
+	for i, f := range db.feeds {
+		if f.ID == id {
+			db.feeds[i].LastChecked = sql.NullTime{Valid: true, Time: timeNow()}
+			db.feeds[i].LastError = fetchErr.Error()
+			return rewrite(&db.csvFeeds, feedsToRecs(db.feeds...))
+		}
 	}
+	return sql.ErrNoRows
+}
 
-	feeds := make([]Feed, len(db.feeds))
-	copy(feeds, db.feeds)
-	return feeds, nil
+// EditFeedName sets the name of the feed,
+// an empty name resets it to the title of the feed.
+func (db *DB) EditFeedName(id int, name string) error {
+	if err := db.lock(); err != nil {
+		return err
+	}
+	defer db.unlock()
+
+	for i, f := range db.feeds {
+		if f.ID == id {
+			db.feeds[i].Name = name
+			return rewrite(&db.csvFeeds, feedsToRecs(db.feeds...))
+		}
+	}
+	return sql.ErrNoRows
 }
 
-func (db *DB) EditFeedHost(id int, newHost string) error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+// SetFeedMeta stores what the feed tells about itself.
+func (db *DB) SetFeedMeta(id int, meta FeedMeta) error {
+	if err := db.lock(); err != nil {
+		return err
+	}
+	defer db.unlock()
 
 	for i, f := range db.feeds {
 		if f.ID == id {
-			db.feeds[i].Host = newHost
-			return rewrite(db.csvFeeds, feedsToRecs(db.feeds...))
+			if f.FeedMeta == meta {
+				return nil
+			}
+			db.feeds[i].FeedMeta = meta
+			return rewrite(&db.csvFeeds, feedsToRecs(db.feeds...))
+		}
+	}
+	return sql.ErrNoRows
+}
+
+// SetFeedSkipped stores the number of unusable items in the last fetch.
+func (db *DB) SetFeedSkipped(id int, skipped int) error {
+	if err := db.lock(); err != nil {
+		return err
+	}
+	defer db.unlock()
+
+	for i, f := range db.feeds {
+		if f.ID == id {
+			if f.Skipped == skipped {
+				return nil
+			}
+			db.feeds[i].Skipped = skipped
+			return rewrite(&db.csvFeeds, feedsToRecs(db.feeds...))
+		}
+	}
+	return sql.ErrNoRows
+}
+
+// SetFeedDisabled excludes the feed from scheduled updates or includes it again.
+func (db *DB) SetFeedDisabled(id int, disabled bool) error {
+	if err := db.lock(); err != nil {
+		return err
+	}
+	defer db.unlock()
+
+	for i, f := range db.feeds {
+		if f.ID == id {
+			if f.Disabled == disabled {
+				return nil
+			}
+			db.feeds[i].Disabled = disabled
+			return rewrite(&db.csvFeeds, feedsToRecs(db.feeds...))
+		}
+	}
+	return sql.ErrNoRows
+}
+
+// SetFeedOptions stores the sealed fetch options of the feed.
+func (db *DB) SetFeedOptions(id int, options string) error {
+	if err := db.lock(); err != nil {
+		return err
+	}
+	defer db.unlock()
+
+	for i, f := range db.feeds {
+		if f.ID == id {
+			db.feeds[i].Options = options
+			return rewrite(&db.csvFeeds, feedsToRecs(db.feeds...))
+		}
+	}
+	return sql.ErrNoRows
+}
+
+// SetFeedURL changes the URL the feed is fetched from,
+// it returns ErrFound if another feed already uses it.
+func (db *DB) SetFeedURL(id int, feedURL string) error {
+	if err := db.lock(); err != nil {
+		return err
+	}
+	defer db.unlock()
+
+	for _, f := range db.feeds {
+		if f.FeedURL == feedURL && f.ID != id {
+			return ErrFound
+		}
+	}
+	for i, f := range db.feeds {
+		if f.ID == id {
+			db.feeds[i].FeedURL = feedURL
+			db.feeds[i].Host = HostOf(feedURL)
+			return rewrite(&db.csvFeeds, feedsToRecs(db.feeds...))
 		}
 	}
 	return sql.ErrNoRows
@@ -336,50 +793,99 @@ func (db *DB) EditFeedHost(id int, newHost string) error {
 func (db *DB) ItemCount() (int, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
-	return len(db.items), nil
+	return db.items.count(), nil
 }
 
 func (db *DB) Newest(offset, limit uint) ([]ItemWithHost, error) {
-	less := func(i, j int) bool {
-		return db.items[i].Added.After(db.items[j].Added)
-	}
-
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 
-	if !sort.SliceIsSorted(db.items, less) {
-		sort.Slice(db.items, less)
+	items := page(&db.items, offset, limit)
+	if len(items) == 0 {
+		return nil, sql.ErrNoRows
 	}
 
-	count := uint(len(db.items))
-	if offset >= count {
-		return nil, sql.ErrNoRows
+	m := make(map[int]Feed, len(db.feeds))
+	for _, f := range db.feeds {
+		m[f.ID] = f
 	}
 
-	limit += offset
-	if limit >= count {
-		limit = count
+	iwh := make([]ItemWithHost, 0, len(items))
+	for _, item := range items {
+		f, ok := m[item.FeedID]
+		if !ok {
+			return nil, fmt.Errorf("unknown feed id %d", item.FeedID)
+		}
+		iwh = append(iwh, ItemWithHost{Item: *item, Host: f.Host, FeedName: f.DisplayName()})
 	}
+	return iwh, nil
+}
+
+// LastItemID returns the ID of the last added item, or 0.
+// IDs grow with every added item.
+func (db *DB) LastItemID() (int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.nextItemID - 1, nil
+}
 
-	m := make(map[int]Feed)
+// ItemsSince returns the items added after the one with id, newest first.
+func (db *DB) ItemsSince(id int) ([]ItemWithHost, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	m := make(map[int]Feed, len(db.feeds))
 	for _, f := range db.feeds {
 		m[f.ID] = f
 	}
 
-	iwh := make([]ItemWithHost, 0)
-	for _, item := range db.items[offset:limit] {
+	items := make([]*Item, 0)
+	for i := id + 1; i < db.nextItemID; i++ {
+		if item, ok := db.byID[i]; ok {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return itemLess(items[j], items[i])
+	})
+
+	iwh := make([]ItemWithHost, 0, len(items))
+	for _, item := range items {
 		f, ok := m[item.FeedID]
 		if !ok {
 			return nil, fmt.Errorf("unknown feed id %d", item.FeedID)
 		}
-		iwh = append(iwh, ItemWithHost{Item: item, Host: f.Host})
+		iwh = append(iwh, ItemWithHost{Item: *item, Host: f.Host, FeedName: f.DisplayName()})
 	}
 	return iwh, nil
 }
 
+// FeedItems returns the items of a single feed, newest first.
+func (db *DB) FeedItems(feedID int, offset, limit uint) ([]Item, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var items []*Item
+	if fi, ok := db.byFeed[feedID]; ok {
+		items = page(&fi.items, offset, limit)
+	}
+	if len(items) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	out := make([]Item, 0, len(items))
+	for _, item := range items {
+		out = append(out, *item)
+	}
+	return out, nil
+}
+
+// RemoveFeed removes the feed with its items and rules or returns sql.ErrNoRows.
 func (db *DB) RemoveFeed(id int) error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	if err := db.lock(); err != nil {
+		return err
+	}
+	defer db.unlock()
 
 	found := false
 	for i, f := range db.feeds {
@@ -390,21 +896,104 @@ func (db *DB) RemoveFeed(id int) error {
 		}
 	}
 	if !found {
-		return fmt.Errorf("unknown feed id %d", id)
+		return sql.ErrNoRows
+	}
+	if err := rewrite(&db.csvFeeds, feedsToRecs(db.feeds...)); err != nil {
+		return err
+	}
+
+	db.removeItems(func(item *Item) bool {
+		return item.FeedID == id
+	})
+	if err := db.rewriteItems(); err != nil {
+		return err
+	}
+
+	for key := range db.seen {
+		if key.feedID == id {
+			delete(db.seen, key)
+		}
+	}
+	if err := db.rewriteSeen(); err != nil {
+		return err
 	}
-	if err := rewrite(db.csvFeeds, feedsToRecs(db.feeds...)); err != nil {
+
+	return db.removeRules(func(r Rule) bool {
+		return r.FeedID == id
+	})
+}
+
+func (db *DB) SetRead(id int, read bool) error {
+	return db.editItem(id, func(item *Item) {
+		item.Read = read
+	})
+}
+
+func (db *DB) SetStarred(id int, starred bool) error {
+	return db.editItem(id, func(item *Item) {
+		item.Starred = starred
+	})
+}
+
+// SetFeedRead marks all items of the feed as read.
+func (db *DB) SetFeedRead(feedID int) error {
+	if err := db.lock(); err != nil {
 		return err
 	}
+	defer db.unlock()
 
-	keep := make([]Item, 0)
-	for _, item := range db.items {
-		if item.FeedID != id {
-			keep = append(keep, item)
+	fi, ok := db.byFeed[feedID]
+	if !ok {
+		return nil
+	}
+	changed := make([]*Item, 0)
+	for _, item := range fi.items.all() {
+		if !item.Read {
+			item.Read = true
+			changed = append(changed, item)
 		}
 	}
-	db.items = keep
+	return db.writeItems(changed...)
+}
+
+func (db *DB) editItem(id int, edit func(*Item)) error {
+	if err := db.lock(); err != nil {
+		return err
+	}
+	defer db.unlock()
+
+	item, ok := db.byID[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	edit(item)
+	return db.writeItems(item)
+}
+
+// writeItems appends the rows of new or changed items to the items file.
+// The file is rewritten once it holds too many rows of changed items.
+func (db *DB) writeItems(items ...*Item) error {
+	if len(items) == 0 {
+		return nil
+	}
+	if err := insert(db.csvItems, itemsToRecs(items...)); err != nil {
+		return err
+	}
+	db.itemRows += len(items)
+	if db.itemRows > 2*db.items.count()+compactRows {
+		return db.rewriteItems()
+	}
+	return nil
+}
 
-	return rewrite(db.csvItems, itemsToRecs(db.items...))
+// rewriteItems writes all items to the items file, one row per item.
+func (db *DB) rewriteItems() error {
+	items := db.items.all()
+	if err := rewrite(&db.csvItems, itemsToRecs(items...)); err != nil {
+		return err
+	}
+	db.itemRows = len(items)
+	return nil
 }
 
 func (db *DB) bumpCtr() (int, error) {
@@ -438,12 +1027,39 @@ func insert(f *os.File, recs [][]string) error {
 	return csv.NewWriter(f).WriteAll(recs)
 }
 
-func rewrite(f *os.File, recs [][]string) error {
-	if err := f.Truncate(0); err != nil {
+// rewrite replaces the contents of *f with recs. The rows are written to
+// a temporary file that is renamed over *f, so a failed write keeps the
+// old contents. *f is reopened afterwards.
+func rewrite(f **os.File, recs [][]string) error {
+	name := (*f).Name()
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".tmp-")
+	if err != nil {
 		return err
 	}
-	if _, err := f.Seek(0, io.SeekStart); err != nil {
+	defer os.Remove(tmp.Name())
+
+	err = csv.NewWriter(tmp).WriteAll(recs)
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
 		return err
 	}
-	return csv.NewWriter(f).WriteAll(recs)
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return err
+	}
+
+	reopened, err := os.OpenFile(name, os.O_RDWR|os.O_SYNC, 0644)
+	if err != nil {
+		return err
+	}
+	(*f).Close()
+	*f = reopened
+	return nil
 }
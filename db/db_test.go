@@ -4,8 +4,8 @@ import (
 	"database/sql"
 	"io/ioutil"
 	"os"
-	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"testing"
 	"time"
@@ -18,11 +18,7 @@ func TestDB(t *testing.T) {
 	}
 	defer os.RemoveAll(dir)
 
-	// d, err := Init("ctr.csv", "feeds.csv", "items.csv")
-	path := func(path string) string {
-		return filepath.Join(dir, path)
-	}
-	d, err := Open(path("ctr.csv"), path("feeds.csv"), path("items.csv"))
+	d, err := Open(dir)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -59,9 +55,13 @@ func TestDB(t *testing.T) {
 		t.Fatalf("feeds don't match after store")
 	}
 
-	newHost := "blubber"
-	feeds[1].Host = newHost
-	if err := d.EditFeedHost(feeds[1].ID, newHost); err != nil {
+	newName := "blubber"
+	feeds[1].Name = newName
+	if err := d.EditFeedName(feeds[1].ID, newName); err != nil {
+		t.Fatal(err)
+	}
+	feeds[0].Skipped = 3
+	if err := d.SetFeedSkipped(feeds[0].ID, 3); err != nil {
 		t.Fatal(err)
 	}
 	feeds2, err = d.AllFeeds()
@@ -72,7 +72,7 @@ func TestDB(t *testing.T) {
 		t.Fatalf("feeds don't match after store")
 	}
 
-	if err := d.AddItems(999, nil); err == nil {
+	if _, err := d.AddItems(999, nil); err == nil {
 		t.Fatal("expected an err, got nil")
 	}
 
@@ -91,11 +91,19 @@ func TestDB(t *testing.T) {
 			Added:  timeNow(),
 		}
 		items = append(items, item)
-		iwh = append(iwh, ItemWithHost{Item: item, Host: feeds[1].Host})
+		item.ID = i + 1
+		iwh = append(iwh, ItemWithHost{Item: item, Host: feeds[1].Host, FeedName: newName})
 	}
 
-	if err := d.AddItems(feeds[1].ID, items); err != nil {
+	if n, err := d.AddItems(feeds[1].ID, items); err != nil {
 		t.Fatal(err)
+	} else if n != len(items) {
+		t.Fatalf("added %d items, expected %d", n, len(items))
+	}
+	if n, err := d.AddItems(feeds[1].ID, items); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("added %d known items again", n)
 	}
 	iwh1, err := d.Newest(0, 30)
 	if err != nil {
@@ -106,9 +114,10 @@ func TestDB(t *testing.T) {
 	}
 
 	newItem := Item{FeedID: feeds[2].ID, Title: "some", URL: "thing", Added: timeNow()}
-	newIWH := ItemWithHost{Item: newItem, Host: feeds[2].Host}
+	newIWH := ItemWithHost{Item: newItem, Host: feeds[2].Host, FeedName: feeds[2].Host}
+	newIWH.ID = 4
 	iwh = append(iwh, newIWH)
-	if err := d.AddItems(feeds[2].ID, []Item{newItem}); err != nil {
+	if _, err := d.AddItems(feeds[2].ID, []Item{newItem}); err != nil {
 		t.Fatal(err)
 	}
 	nullNow := func() sql.NullTime {
@@ -155,3 +164,609 @@ func TestDB(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestCompact(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "feeder-db-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	now := time.Date(2019, time.December, 31, 12, 12, 12, 0, time.Local)
+	defer func(f func() time.Time) { timeNow = f }(timeNow)
+	timeNow = func() time.Time {
+		return now
+	}
+
+	id, err := d.AddFeed("host", "url")
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := make([]Item, 0)
+	for i := 0; i < 5; i++ {
+		s := strconv.Itoa(i)
+		items = append(items, Item{
+			Title: "title" + s,
+			URL:   "url" + s,
+			Added: now.Add(-time.Duration(i) * 24 * time.Hour),
+		})
+	}
+	if _, err := d.AddItems(id, items); err != nil {
+		t.Fatal(err)
+	}
+	// url3 is the second oldest item.
+	if err := d.SetStarred(4, true); err != nil {
+		t.Fatal(err)
+	}
+
+	r := Retention{MaxAge: 12 * time.Hour, MaxPerFeed: 3, KeepStarred: true}
+	n, err := d.Compact(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("removed %d items, expected 3", n)
+	}
+	if count, _ := d.ItemCount(); count != 2 {
+		t.Fatalf("%d items left, expected 2", count)
+	}
+
+	if n, err := d.AddItems(id, items); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("added %d removed items again", n)
+	}
+
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	d, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iwh, err := d.Newest(0, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	urls := make([]string, 0)
+	for _, item := range iwh {
+		urls = append(urls, item.URL)
+	}
+	if !reflect.DeepEqual(urls, []string{"url0", "url3"}) {
+		t.Fatalf("unexpected items after reopen %v", urls)
+	}
+	if n, err := d.AddItems(id, items); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("added %d removed items again after reopen", n)
+	}
+
+	// The refreshed last seen times are kept after a reopen.
+	now = now.Add(20 * time.Hour)
+	if _, err := d.AddItems(id, items); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if d, err = Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(20 * time.Hour)
+	r.SeenAge = 24 * time.Hour
+	if _, err := d.Compact(r); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := d.AddItems(id, items); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("added %d items seen recently", n)
+	}
+
+	now = now.Add(48 * time.Hour)
+	if _, err := d.Compact(r); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := d.AddItems(id, items[1:2]); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatalf("added %d items, expected the expired one", n)
+	}
+}
+
+func TestAddItemsIdentity(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "feeder-db-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	d.FlagEdited = true
+
+	id, err := d.AddFeed("host", "url")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items := []Item{
+		{GUID: "1", Title: "v1", URL: "https://example.com/changelog", Digest: "a"},
+		{GUID: "2", Title: "v2", URL: "https://example.com/changelog", Digest: "b"},
+		{Title: "post", URL: "https://Example.com/post?utm_source=rss&b=2&a=1#top", Digest: "c"},
+	}
+	if n, err := d.AddItems(id, items); err != nil {
+		t.Fatal(err)
+	} else if n != 3 {
+		t.Fatalf("added %d items, expected 3", n)
+	}
+
+	items = []Item{
+		{GUID: "1", Title: "v1", URL: "https://example.com/changelog?utm_medium=feed", Digest: "a"},
+		{GUID: "2", Title: "v2 fixed", URL: "https://example.com/changelog", Digest: "d"},
+		{Title: "post", URL: "https://example.com:443/post?a=1&b=2", Digest: "c"},
+	}
+	if n, err := d.AddItems(id, items); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("added %d known items again", n)
+	}
+
+	iwh, err := d.Newest(0, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range iwh {
+		edited := item.GUID == "2"
+		if item.Edited != edited {
+			t.Errorf("item %q: edited is %v, expected %v", item.GUID, item.Edited, edited)
+		}
+		if edited && item.Title != "v2 fixed" {
+			t.Errorf("edited item was not updated, title %q", item.Title)
+		}
+	}
+}
+
+func TestItemLog(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "feeder-db-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { d.Close() }()
+
+	id, err := d.AddFeed("host", "url")
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := []Item{{URL: "1", Added: time.Unix(1, 0)}, {URL: "2", Added: time.Unix(2, 0)}}
+	if _, err := d.AddItems(id, items); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < compactRows; i++ {
+		if err := d.SetRead(1, i%2 == 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.SetStarred(2, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetFeedRead(id); err != nil {
+		t.Fatal(err)
+	}
+	if d.itemRows > 2*2+compactRows {
+		t.Errorf("items file has %d rows", d.itemRows)
+	}
+
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if d, err = Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	got, err := d.FeedItems(id, 0, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || !got[0].Read || !got[0].Starred || !got[1].Read || got[1].Starred {
+		t.Fatalf("unexpected items after reopen %+v", got)
+	}
+}
+
+func TestShared(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "feeder-db-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d1, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d1.Close()
+	d2, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d2.Close()
+
+	id, err := d1.AddFeed("host", "url")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d1.AddItems(id, []Item{{URL: "1", Added: time.Unix(1, 0)}}); err != nil {
+		t.Fatal(err)
+	}
+	// Writes load the changes of the other DB first.
+	id2, err := d2.AddFeed("host", "url2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d2.SetRead(1, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d1.AddItems(id, []Item{{URL: "2", Added: time.Unix(2, 0)}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d2.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []*DB{d1, d2} {
+		feeds, err := d.AllFeeds()
+		if err != nil || len(feeds) != 2 {
+			t.Fatalf("got feeds %+v, %v", feeds, err)
+		}
+		items, err := d.FeedItems(id, 0, 30)
+		if err != nil || len(items) != 2 || items[0].Read || !items[1].Read {
+			t.Fatalf("got items %+v, %v", items, err)
+		}
+	}
+
+	if err := d1.RemoveFeed(id); err != nil {
+		t.Fatal(err)
+	}
+	if err := d2.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d2.FeedItems(id, 0, 30); err != sql.ErrNoRows {
+		t.Fatalf("got %v after the feed was removed", err)
+	}
+	feeds, err := d2.AllFeeds()
+	if err != nil || len(feeds) != 1 || feeds[0].ID != id2 {
+		t.Fatalf("got feeds %+v, %v after the feed was removed", feeds, err)
+	}
+}
+
+func TestItemList(t *testing.T) {
+	var l itemList
+	want := make([]*Item, 0)
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5*chunkSize; i++ {
+		// Every third item is older than the others.
+		added := start.Add(time.Duration(i) * time.Minute)
+		if i%3 == 0 {
+			added = start.Add(-time.Duration(i) * time.Minute)
+		}
+		item := &Item{ID: i + 1, Added: added}
+		l.insert(item)
+		want = append(want, item)
+	}
+	sort.Slice(want, func(i, j int) bool {
+		return itemLess(want[i], want[j])
+	})
+
+	if got := l.all(); !reflect.DeepEqual(got, want) {
+		t.Fatal("items are not ordered")
+	}
+	if got := l.slice(chunkSize-1, 3*chunkSize+1); !reflect.DeepEqual(got, want[chunkSize-1:3*chunkSize+1]) {
+		t.Fatal("slice doesn't match")
+	}
+	key := want[2*chunkSize+7]
+	if i := l.search(func(item *Item) bool { return !itemLess(item, key) }); i != 2*chunkSize+7 {
+		t.Fatalf("found index %d, expected %d", i, 2*chunkSize+7)
+	}
+}
+
+func benchDB(b *testing.B, feeds, itemsPerFeed int) (*DB, []int, func()) {
+	dir, err := ioutil.TempDir(os.TempDir(), "feeder-db-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	d, err := Open(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		b.Fatal(err)
+	}
+	cleanup := func() {
+		d.Close()
+		os.RemoveAll(dir)
+	}
+
+	start := time.Date(2019, time.December, 31, 12, 12, 12, 0, time.UTC)
+	ids := make([]int, 0)
+	for f := 0; f < feeds; f++ {
+		id, err := d.AddFeed("host", "url"+strconv.Itoa(f))
+		if err != nil {
+			cleanup()
+			b.Fatal(err)
+		}
+		ids = append(ids, id)
+
+		items := make([]Item, 0, itemsPerFeed)
+		for i := 0; i < itemsPerFeed; i++ {
+			s := strconv.Itoa(f*itemsPerFeed + i)
+			items = append(items, Item{
+				GUID:  s,
+				Title: "title" + s,
+				URL:   "https://example.com/" + s,
+				Added: start.Add(time.Duration(i*feeds+f) * time.Minute),
+			})
+		}
+		if _, err := d.AddItems(id, items); err != nil {
+			cleanup()
+			b.Fatal(err)
+		}
+	}
+	return d, ids, cleanup
+}
+
+func BenchmarkAddItemsKnown(b *testing.B) {
+	d, ids, cleanup := benchDB(b, 100, 1000)
+	defer cleanup()
+
+	items, err := d.FeedItems(ids[0], 0, 30)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if n, err := d.AddItems(ids[0], items); err != nil {
+			b.Fatal(err)
+		} else if n != 0 {
+			b.Fatalf("added %d known items", n)
+		}
+	}
+}
+
+func BenchmarkAddItemsNew(b *testing.B) {
+	d, ids, cleanup := benchDB(b, 100, 1000)
+	defer cleanup()
+
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s := "new" + strconv.Itoa(i)
+		items := []Item{{GUID: s, Title: s, URL: s, Added: now.Add(time.Duration(i) * time.Second)}}
+		if _, err := d.AddItems(ids[i%len(ids)], items); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAddItemsOutOfOrder(b *testing.B) {
+	d, ids, cleanup := benchDB(b, 100, 1000)
+	defer cleanup()
+
+	start := time.Date(2019, time.December, 31, 12, 12, 12, 0, time.UTC)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s := "old" + strconv.Itoa(i)
+		// Between the stored items, which span 100000 minutes.
+		added := start.Add(time.Duration(i%100000)*time.Minute + time.Second)
+		items := []Item{{GUID: s, Title: s, URL: s, Added: added}}
+		if _, err := d.AddItems(ids[i%len(ids)], items); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSetRead(b *testing.B) {
+	d, _, cleanup := benchDB(b, 100, 1000)
+	defer cleanup()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := d.SetRead(1+i*7919%100000, i%2 == 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNewest(b *testing.B) {
+	d, _, cleanup := benchDB(b, 100, 1000)
+	defer cleanup()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := d.Newest(uint(i%1000)*30, 30); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFeedItems(b *testing.B) {
+	d, ids, cleanup := benchDB(b, 100, 1000)
+	defer cleanup()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := d.FeedItems(ids[i%len(ids)], uint(i%30)*30, 30); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestPages(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "feeder-db-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	if p, err := d.Page(PageQuery{Limit: 2}); err != nil {
+		t.Fatal(err)
+	} else if len(p.Items) != 0 || p.Newer != nil || p.Older != nil {
+		t.Fatalf("unexpected page of empty db %+v", p)
+	}
+
+	id, err := d.AddFeed("host", "url")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2019, time.December, 31, 12, 12, 12, 0, time.UTC)
+	items := make([]Item, 0)
+	for i := 0; i < 5; i++ {
+		s := strconv.Itoa(i)
+		items = append(items, Item{Title: s, URL: s, Added: start.Add(time.Duration(i) * time.Hour)})
+	}
+	if _, err := d.AddItems(id, items); err != nil {
+		t.Fatal(err)
+	}
+
+	titles := func(p Page) []string {
+		s := make([]string, 0)
+		for _, item := range p.Items {
+			s = append(s, item.Title)
+		}
+		return s
+	}
+
+	p, err := d.Page(PageQuery{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(titles(p), []string{"4", "3"}) || p.Newer != nil || p.Older == nil {
+		t.Fatalf("unexpected first page %v", titles(p))
+	}
+
+	// A new item does not shift the following pages.
+	if _, err := d.AddItems(id, []Item{{Title: "5", URL: "5", Added: start.Add(5 * time.Hour)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := ParseCursor(p.Older.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err = d.Page(PageQuery{Before: &c, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(titles(p), []string{"2", "1"}) || p.Newer == nil || p.Older == nil {
+		t.Fatalf("unexpected second page %v", titles(p))
+	}
+
+	p, err = d.Page(PageQuery{After: p.Newer, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(titles(p), []string{"4", "3"}) || p.Newer == nil {
+		t.Fatalf("unexpected previous page %v", titles(p))
+	}
+
+	other, err := d.AddFeed("host", "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.AddItems(other, []Item{{Title: "other", URL: "other", Added: start.Add(time.Hour)}}); err != nil {
+		t.Fatal(err)
+	}
+	p, err = d.Page(PageQuery{FeedID: id, Before: &c, Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(titles(p), []string{"2", "1", "0"}) || p.Older != nil {
+		t.Fatalf("unexpected feed page %v", titles(p))
+	}
+}
+
+func TestRules(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "feeder-db-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feedID, err := d.AddFeed("host", "url")
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := Item{Title: "t", URL: "https://example.com/1", Added: time.Unix(1, 0).UTC(), Tags: []string{"a", "b"}}
+	if _, err := d.AddItems(feedID, []Item{item}); err != nil {
+		t.Fatal(err)
+	}
+
+	rules := []Rule{
+		{Field: "title", Match: "substring", Pattern: "sponsored", Action: "drop"},
+		{FeedID: feedID, Field: "any", Match: "regexp", Pattern: "(?i)go,lang", OlderThan: 48 * time.Hour, Action: "tag", Tag: "go"},
+		{FeedID: feedID, Field: "category", Match: "glob", Pattern: "news*", Action: "read"},
+	}
+	for i := range rules {
+		if rules[i].ID, err = d.AddRule(rules[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := d.AddRule(Rule{FeedID: feedID + 1, Action: "drop"}); err != sql.ErrNoRows {
+		t.Fatalf("added a rule for an unknown feed, %v", err)
+	}
+	if err := d.RemoveRule(rules[2].ID); err != nil {
+		t.Fatal(err)
+	}
+	rules = rules[:2]
+	if err := d.RemoveRule(rules[1].ID + 10); err != sql.ErrNoRows {
+		t.Fatalf("removed an unknown rule, %v", err)
+	}
+
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if d, err = Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	got, err := d.Rules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, rules) {
+		t.Fatalf("got rules %+v, want %+v", got, rules)
+	}
+	items, err := d.FeedItems(feedID, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || !reflect.DeepEqual(items[0].Tags, item.Tags) {
+		t.Fatalf("tags not stored, got %+v", items)
+	}
+
+	if err := d.RemoveFeed(feedID); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = d.Rules(); err != nil || len(got) != 1 || got[0].FeedID != 0 {
+		t.Fatalf("rules of a removed feed kept, got %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"net/url"
+	"strings"
+)
+
+// identity is the key an item is known by within its feed:
+// the GUID if the feed provides one, the normalized URL otherwise.
+func (item Item) identity() string {
+	if item.GUID != "" {
+		return "guid:" + item.GUID
+	}
+	return "url:" + normalizeURL(item.URL)
+}
+
+// trackingParams are removed from URLs before comparing them.
+var trackingParams = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"ref":     true,
+	"ref_src": true,
+}
+
+// normalizeURL returns a form of rawURL that is equal for URLs that only
+// differ in case of the scheme and host, default ports, fragments,
+// tracking parameters or the order of query parameters.
+func normalizeURL(rawURL string) string {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return rawURL
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if (u.Scheme == "http" && strings.HasSuffix(u.Host, ":80")) ||
+		(u.Scheme == "https" && strings.HasSuffix(u.Host, ":443")) {
+		u.Host = u.Host[:strings.LastIndexByte(u.Host, ':')]
+	}
+	if u.Path == "" && u.Host != "" {
+		u.Path = "/"
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	q := u.Query()
+	for k := range q {
+		if trackingParams[strings.ToLower(k)] || strings.HasPrefix(strings.ToLower(k), "utm_") {
+			q.Del(k)
+		}
+	}
+	// Encode sorts by key.
+	u.RawQuery = q.Encode()
+	u.ForceQuery = false
+	return u.String()
+}
+
+// HostOf returns the scheme and host of rawURL.
+func HostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
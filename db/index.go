@@ -0,0 +1,212 @@
+package db
+
+import "sort"
+
+// itemLess orders items from oldest to newest. Items added at the same
+// time are ordered by descending ID, so the newest first order keeps the
+// order of the feed.
+func itemLess(a, b *Item) bool {
+	if !a.Added.Equal(b.Added) {
+		return a.Added.Before(b.Added)
+	}
+	return a.ID > b.ID
+}
+
+// chunkSize is the size of the chunks of an itemList.
+// A chunk is split in half once it is twice as large.
+const chunkSize = 256
+
+// itemList holds items ordered by itemLess in chunks, so inserting an
+// item that is not the newest only moves the items of a single chunk.
+// New items usually are the newest, so they are appended.
+type itemList struct {
+	chunks [][]*Item // never empty
+	starts []int     // index of the first item of each chunk
+	n      int
+}
+
+func (l *itemList) count() int {
+	return l.n
+}
+
+func (l *itemList) insert(item *Item) {
+	c := len(l.chunks) - 1
+	if c < 0 {
+		l.chunks = append(l.chunks, make([]*Item, 0, chunkSize))
+		l.starts = append(l.starts, 0)
+		c = 0
+	} else if last := l.chunks[c]; itemLess(item, last[len(last)-1]) {
+		c = sort.Search(len(l.chunks), func(i int) bool {
+			chunk := l.chunks[i]
+			return itemLess(item, chunk[len(chunk)-1])
+		})
+	}
+
+	chunk := l.chunks[c]
+	i := sort.Search(len(chunk), func(i int) bool {
+		return itemLess(item, chunk[i])
+	})
+	chunk = append(chunk, nil)
+	copy(chunk[i+1:], chunk[i:])
+	chunk[i] = item
+	l.chunks[c] = chunk
+	for j := c + 1; j < len(l.starts); j++ {
+		l.starts[j]++
+	}
+	l.n++
+
+	if len(chunk) >= 2*chunkSize {
+		half := make([]*Item, len(chunk)-chunkSize, 2*chunkSize)
+		copy(half, chunk[chunkSize:])
+		l.chunks = append(l.chunks, nil)
+		copy(l.chunks[c+2:], l.chunks[c+1:])
+		l.chunks[c] = chunk[:chunkSize:chunkSize]
+		l.chunks[c+1] = half
+		l.starts = append(l.starts, 0)
+		copy(l.starts[c+2:], l.starts[c+1:])
+		l.starts[c+1] = l.starts[c] + chunkSize
+	}
+}
+
+// search returns the index of the first item for which f is true.
+// f must be false for the items before it and true for the ones after it.
+func (l *itemList) search(f func(*Item) bool) int {
+	c := sort.Search(len(l.chunks), func(i int) bool {
+		chunk := l.chunks[i]
+		return f(chunk[len(chunk)-1])
+	})
+	if c == len(l.chunks) {
+		return l.n
+	}
+	chunk := l.chunks[c]
+	return l.starts[c] + sort.Search(len(chunk), func(i int) bool {
+		return f(chunk[i])
+	})
+}
+
+// slice returns the items from index lo up to hi.
+func (l *itemList) slice(lo, hi int) []*Item {
+	out := make([]*Item, 0, hi-lo)
+	if lo >= hi {
+		return out
+	}
+	c := sort.Search(len(l.starts), func(i int) bool {
+		return l.starts[i] > lo
+	}) - 1
+	for i := lo - l.starts[c]; len(out) < hi-lo; c, i = c+1, 0 {
+		chunk := l.chunks[c][i:]
+		if rest := hi - lo - len(out); len(chunk) > rest {
+			chunk = chunk[:rest]
+		}
+		out = append(out, chunk...)
+	}
+	return out
+}
+
+// all returns the items ordered by itemLess.
+func (l *itemList) all() []*Item {
+	return l.slice(0, l.n)
+}
+
+// feedItems indexes the items of a single feed.
+type feedItems struct {
+	items  itemList
+	byGUID map[string]*Item
+	byURL  map[string]*Item // by normalized URL
+}
+
+func (db *DB) indexItem(item *Item) {
+	db.items.insert(item)
+	db.byID[item.ID] = item
+
+	fi, ok := db.byFeed[item.FeedID]
+	if !ok {
+		fi = &feedItems{
+			byGUID: make(map[string]*Item),
+			byURL:  make(map[string]*Item),
+		}
+		db.byFeed[item.FeedID] = fi
+	}
+	fi.items.insert(item)
+	if item.GUID != "" {
+		fi.byGUID[item.GUID] = item
+	}
+	fi.byURL[normalizeURL(item.URL)] = item
+}
+
+// replaceItem replaces stored with a later row of the same item.
+// The rows of an item only differ in fields that don't affect its order.
+func (db *DB) replaceItem(stored *Item, item Item) {
+	fi := db.byFeed[stored.FeedID]
+	if stored.GUID != "" && fi.byGUID[stored.GUID] == stored {
+		delete(fi.byGUID, stored.GUID)
+	}
+	if old := normalizeURL(stored.URL); fi.byURL[old] == stored {
+		delete(fi.byURL, old)
+	}
+	*stored = item
+	if stored.GUID != "" {
+		fi.byGUID[stored.GUID] = stored
+	}
+	fi.byURL[normalizeURL(stored.URL)] = stored
+}
+
+// reindex replaces all items. items must be ordered by itemLess.
+func (db *DB) reindex(items []*Item) {
+	db.items = itemList{}
+	db.byID = make(map[int]*Item, len(items))
+	db.byFeed = make(map[int]*feedItems)
+	for _, item := range items {
+		db.indexItem(item)
+	}
+}
+
+// removeItems removes all items for which remove returns true.
+func (db *DB) removeItems(remove func(*Item) bool) {
+	keep := make([]*Item, 0, db.items.count())
+	for _, item := range db.items.all() {
+		if !remove(item) {
+			keep = append(keep, item)
+		}
+	}
+	db.reindex(keep)
+}
+
+// lookup returns the stored item of the feed that item is an entry for.
+func (db *DB) lookup(feedID int, item Item) (*Item, bool) {
+	fi, ok := db.byFeed[feedID]
+	if !ok {
+		return nil, false
+	}
+	if item.GUID != "" {
+		if stored, ok := fi.byGUID[item.GUID]; ok {
+			return stored, true
+		}
+	}
+	stored, ok := fi.byURL[normalizeURL(item.URL)]
+	// Entries with different GUIDs may share a URL.
+	if ok && (item.GUID == "" || stored.GUID == "") {
+		return stored, true
+	}
+	return nil, false
+}
+
+// page returns up to limit items of items, newest first,
+// skipping the offset newest ones.
+func page(items *itemList, offset, limit uint) []*Item {
+	count := uint(items.count())
+	if offset >= count {
+		return nil
+	}
+	end := count - offset
+	start := uint(0)
+	if limit < end {
+		start = end - limit
+	}
+
+	out := items.slice(int(start), int(end))
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
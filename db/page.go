@@ -0,0 +1,136 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cursor is the position of an item in the newest first order.
+type Cursor struct {
+	Added time.Time
+	ID    int
+}
+
+func CursorOf(item Item) Cursor {
+	return Cursor{Added: item.Added, ID: item.ID}
+}
+
+// String returns the cursor in the form UNIXNANO-ID.
+func (c Cursor) String() string {
+	return strconv.FormatInt(c.Added.UnixNano(), 10) + "-" + strconv.Itoa(c.ID)
+}
+
+func ParseCursor(s string) (Cursor, error) {
+	i := strings.LastIndexByte(s, '-')
+	if i <= 0 {
+		return Cursor{}, errors.New("cursor: missing separator")
+	}
+	nano, err := strconv.ParseInt(s[:i], 10, 64)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("cursor: invalid time, %v", err)
+	}
+	id, err := strconv.Atoi(s[i+1:])
+	if err != nil {
+		return Cursor{}, fmt.Errorf("cursor: invalid id, %v", err)
+	}
+	return Cursor{Added: time.Unix(0, nano), ID: id}, nil
+}
+
+func (c Cursor) item() *Item {
+	return &Item{Added: c.Added, ID: c.ID}
+}
+
+// Page is a part of the items in newest first order.
+type Page struct {
+	Items []ItemWithHost
+	// Newer and Older point to the neighbouring pages,
+	// they are nil if there are no newer or older items.
+	Newer, Older *Cursor
+}
+
+// PageQuery selects a page of items.
+type PageQuery struct {
+	// FeedID restricts the items to a single feed if it is not 0.
+	FeedID int
+	// Before selects the items added before the cursor, After the ones
+	// added after it, closest to the cursor first. If both are nil,
+	// the newest items are selected.
+	Before, After *Cursor
+	Limit         uint
+}
+
+// Page returns the items selected by q, newest first.
+func (db *DB) Page(q PageQuery) (Page, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	items := &db.items
+	if q.FeedID != 0 {
+		fi, ok := db.byFeed[q.FeedID]
+		if !ok {
+			return Page{}, nil
+		}
+		items = &fi.items
+	}
+
+	switch {
+	case q.Before != nil:
+		key := q.Before.item()
+		hi := items.search(func(item *Item) bool {
+			return !itemLess(item, key)
+		})
+		return db.page(items, hi-clamp(q.Limit, hi), hi)
+	case q.After != nil:
+		key := q.After.item()
+		lo := items.search(func(item *Item) bool {
+			return itemLess(key, item)
+		})
+		return db.page(items, lo, lo+clamp(q.Limit, items.count()-lo))
+	default:
+		hi := items.count()
+		return db.page(items, hi-clamp(q.Limit, hi), hi)
+	}
+}
+
+func clamp(limit uint, max int) int {
+	if limit > uint(max) {
+		return max
+	}
+	return int(limit)
+}
+
+// page returns the items from index lo up to hi, newest first.
+func (db *DB) page(items *itemList, lo, hi int) (Page, error) {
+	feeds := make(map[int]Feed, len(db.feeds))
+	for _, f := range db.feeds {
+		feeds[f.ID] = f
+	}
+
+	var p Page
+	p.Items = make([]ItemWithHost, 0, hi-lo)
+	slice := items.slice(lo, hi)
+	for i := len(slice) - 1; i >= 0; i-- {
+		item := slice[i]
+		f, ok := feeds[item.FeedID]
+		if !ok {
+			return Page{}, fmt.Errorf("unknown feed id %d", item.FeedID)
+		}
+		p.Items = append(p.Items, ItemWithHost{Item: *item, Host: f.Host, FeedName: f.DisplayName()})
+	}
+	if len(p.Items) == 0 {
+		return p, nil
+	}
+
+	if hi < items.count() {
+		c := CursorOf(p.Items[0].Item)
+		p.Newer = &c
+	}
+	if lo > 0 {
+		c := CursorOf(p.Items[len(p.Items)-1].Item)
+		p.Older = &c
+	}
+	return p, nil
+}
@@ -0,0 +1,171 @@
+package db
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Retention limits how many items are kept. Zero values mean no limit.
+type Retention struct {
+	// MaxAge removes items added longer ago.
+	MaxAge time.Duration
+	// MaxPerFeed keeps only the newest items of each feed.
+	MaxPerFeed int
+	// KeepUnread and KeepStarred exclude such items from removal.
+	KeepUnread  bool
+	KeepStarred bool
+	// SeenAge is how long the identity of a removed item is remembered after
+	// it was last part of the feed, so it is not added again.
+	// Zero remembers it as long as the feed exists.
+	SeenAge time.Duration
+}
+
+// seenKey identifies an item that was removed by Compact.
+type seenKey struct {
+	feedID int
+	key    string
+}
+
+const (
+	sFeedID   = 0
+	sKey      = 1
+	sLastSeen = 2
+	sLen      = 3
+)
+
+func (db *DB) seenToRecs(keys ...seenKey) [][]string {
+	recs := make([][]string, 0)
+	for _, key := range keys {
+		r := make([]string, sLen)
+		r[sFeedID] = strconv.Itoa(key.feedID)
+		r[sKey] = key.key
+		r[sLastSeen] = db.seen[key].Format(timeFormat)
+		recs = append(recs, r)
+	}
+	return recs
+}
+
+// writeSeen appends the last seen times of the keys to the seen file.
+// Later rows replace the earlier ones, the file is rewritten once it
+// holds too many of them.
+func (db *DB) writeSeen(keys ...seenKey) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if err := insert(db.csvSeen, db.seenToRecs(keys...)); err != nil {
+		return err
+	}
+	db.seenRows += len(keys)
+	if db.seenRows > 2*len(db.seen)+compactRows {
+		return db.rewriteSeen()
+	}
+	return nil
+}
+
+// rewriteSeen writes all keys to the seen file, one row per key.
+func (db *DB) rewriteSeen() error {
+	keys := make([]seenKey, 0, len(db.seen))
+	for key := range db.seen {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].feedID != keys[j].feedID {
+			return keys[i].feedID < keys[j].feedID
+		}
+		return keys[i].key < keys[j].key
+	})
+	if err := rewrite(&db.csvSeen, db.seenToRecs(keys...)); err != nil {
+		return err
+	}
+	db.seenRows = len(keys)
+	return nil
+}
+
+func (db *DB) loadSeen() error {
+	if _, err := db.csvSeen.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	recs, err := csv.NewReader(db.csvSeen).ReadAll()
+	if err != nil {
+		return err
+	}
+	db.seen = make(map[seenKey]time.Time, len(recs))
+	db.seenRows = len(recs)
+	for _, r := range recs {
+		if len(r) != sLen {
+			return errors.New("seen: unexpected row length")
+		}
+
+		var key seenKey
+		key.key = r[sKey]
+		key.feedID, err = strconv.Atoi(r[sFeedID])
+		if err != nil {
+			return err
+		}
+		lastSeen, err := time.Parse(timeFormat, r[sLastSeen])
+		if err != nil {
+			return err
+		}
+		db.seen[key] = lastSeen
+	}
+	return nil
+}
+
+// Compact removes the items that are not retained by r and rewrites the
+// items file. The identities of removed items are remembered, so AddItems
+// ignores them if the feed still contains them. Compact returns the number
+// of removed items.
+func (db *DB) Compact(r Retention) (int, error) {
+	if err := db.lock(); err != nil {
+		return 0, err
+	}
+	defer db.unlock()
+
+	now := timeNow()
+	keepAlways := func(item Item) bool {
+		return (r.KeepUnread && !item.Read) || (r.KeepStarred && item.Starred)
+	}
+
+	remove := make(map[*Item]bool)
+	for _, fi := range db.byFeed {
+		items := fi.items.all()
+		n := 0
+		for i := len(items) - 1; i >= 0; i, n = i-1, n+1 {
+			item := items[i]
+			if keepAlways(*item) {
+				continue
+			}
+			tooOld := r.MaxAge > 0 && now.Sub(item.Added) > r.MaxAge
+			tooMany := r.MaxPerFeed > 0 && n >= r.MaxPerFeed
+			if tooOld || tooMany {
+				remove[item] = true
+			}
+		}
+	}
+
+	if r.SeenAge > 0 {
+		for key, lastSeen := range db.seen {
+			if now.Sub(lastSeen) > r.SeenAge {
+				delete(db.seen, key)
+			}
+		}
+	}
+
+	if len(remove) > 0 {
+		for item := range remove {
+			db.seen[seenKey{feedID: item.FeedID, key: item.identity()}] = now
+		}
+		db.removeItems(func(item *Item) bool {
+			return remove[item]
+		})
+		if err := db.rewriteItems(); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(remove), db.rewriteSeen()
+}
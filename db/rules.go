@@ -0,0 +1,176 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Rule is a stored filter for incoming items, package rules compiles
+// and applies it. The string fields are validated there.
+type Rule struct {
+	ID int
+	// FeedID is 0 for rules that apply to all feeds.
+	FeedID int
+	// Field is the part of the item that Pattern is matched against.
+	Field string
+	// Match is how Pattern is matched.
+	Match   string
+	Pattern string
+	// OlderThan restricts the rule to items dated longer ago, zero
+	// applies it regardless of the date.
+	OlderThan time.Duration
+	Action    string
+	// Tag is added to the item by the tag action.
+	Tag string
+}
+
+const (
+	rID        = 0
+	rFeedID    = 1
+	rField     = 2
+	rMatch     = 3
+	rPattern   = 4
+	rOlderThan = 5
+	rAction    = 6
+	rTag       = 7
+	rLen       = 8
+)
+
+func rulesToRecs(rules ...Rule) [][]string {
+	recs := make([][]string, 0)
+	for _, rule := range rules {
+		r := make([]string, rLen)
+		r[rID] = strconv.Itoa(rule.ID)
+		r[rFeedID] = strconv.Itoa(rule.FeedID)
+		r[rField] = rule.Field
+		r[rMatch] = rule.Match
+		r[rPattern] = rule.Pattern
+		r[rOlderThan] = rule.OlderThan.String()
+		r[rAction] = rule.Action
+		r[rTag] = rule.Tag
+		recs = append(recs, r)
+	}
+	return recs
+}
+
+func (db *DB) loadRules() error {
+	if _, err := db.csvRules.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	recs, err := csv.NewReader(db.csvRules).ReadAll()
+	if err != nil {
+		return err
+	}
+	db.rules = nil
+	for _, r := range recs {
+		if len(r) != rLen {
+			return errors.New("rules: unexpected row length")
+		}
+
+		rule := Rule{
+			Field:   r[rField],
+			Match:   r[rMatch],
+			Pattern: r[rPattern],
+			Action:  r[rAction],
+			Tag:     r[rTag],
+		}
+		rule.ID, err = strconv.Atoi(r[rID])
+		if err != nil {
+			return err
+		}
+		rule.FeedID, err = strconv.Atoi(r[rFeedID])
+		if err != nil {
+			return err
+		}
+		rule.OlderThan, err = time.ParseDuration(r[rOlderThan])
+		if err != nil {
+			return err
+		}
+		db.rules = append(db.rules, rule)
+	}
+	return nil
+}
+
+// Rules returns all rules in the order they were added.
+func (db *DB) Rules() ([]Rule, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	rules := make([]Rule, len(db.rules))
+	copy(rules, db.rules)
+	return rules, nil
+}
+
+// AddRule stores r with a new ID and returns it.
+// The feed of r must exist unless FeedID is 0.
+func (db *DB) AddRule(r Rule) (int, error) {
+	if err := db.lock(); err != nil {
+		return -1, err
+	}
+	defer db.unlock()
+
+	if r.FeedID != 0 {
+		found := false
+		for _, f := range db.feeds {
+			if f.ID == r.FeedID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return -1, sql.ErrNoRows
+		}
+	}
+
+	r.ID = 1
+	for _, stored := range db.rules {
+		if stored.ID >= r.ID {
+			r.ID = stored.ID + 1
+		}
+	}
+	if err := insert(db.csvRules, rulesToRecs(r)); err != nil {
+		return -1, err
+	}
+	db.rules = append(db.rules, r)
+	return r.ID, nil
+}
+
+func (db *DB) RemoveRule(id int) error {
+	if err := db.lock(); err != nil {
+		return err
+	}
+	defer db.unlock()
+
+	found := false
+	for _, r := range db.rules {
+		if r.ID == id {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return sql.ErrNoRows
+	}
+	return db.removeRules(func(r Rule) bool {
+		return r.ID == id
+	})
+}
+
+// removeRules removes the rules for which remove returns true.
+// The caller must hold db.mu.
+func (db *DB) removeRules(remove func(Rule) bool) error {
+	keep := make([]Rule, 0, len(db.rules))
+	for _, r := range db.rules {
+		if !remove(r) {
+			keep = append(keep, r)
+		}
+	}
+	if len(keep) == len(db.rules) {
+		return nil
+	}
+	db.rules = keep
+	return rewrite(&db.csvRules, rulesToRecs(db.rules...))
+}
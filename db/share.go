@@ -0,0 +1,106 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/erikfastermann/feeder/internal/flock"
+)
+
+// lock locks the DB for writing, also for other processes sharing the
+// directory, and loads the files they changed.
+func (db *DB) lock() error {
+	db.mu.Lock()
+	if err := flock.Lock(db.lockFile); err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	if err := db.load(); err != nil {
+		flock.Unlock(db.lockFile)
+		db.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// unlock remembers the files as they were written and unlocks the DB.
+func (db *DB) unlock() {
+	for name, f := range db.files() {
+		if fi, err := (*f).Stat(); err == nil {
+			db.loaded[name] = fi
+		} else {
+			delete(db.loaded, name)
+		}
+	}
+	flock.Unlock(db.lockFile)
+	db.mu.Unlock()
+}
+
+// files returns the files that are loaded into memory, by name.
+func (db *DB) files() map[string]**os.File {
+	return map[string]**os.File{
+		FeedsFile: &db.csvFeeds,
+		ItemsFile: &db.csvItems,
+		SeenFile:  &db.csvSeen,
+		RulesFile: &db.csvRules,
+	}
+}
+
+// load loads the files that changed since they were last loaded or
+// written. Files are replaced when they are rewritten, so they are
+// opened again. If rows were only appended to the items file, just
+// those are read.
+func (db *DB) load() error {
+	dir := filepath.Dir(db.ctr.Name())
+	files := db.files()
+	for _, name := range []string{FeedsFile, ItemsFile, SeenFile, RulesFile} {
+		f := files[name]
+		fi, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		old, ok := db.loaded[name]
+		same := ok && os.SameFile(old, fi)
+		if same && old.Size() == fi.Size() && old.ModTime().Equal(fi.ModTime()) {
+			continue
+		}
+		delete(db.loaded, name)
+
+		if !same {
+			reopened, err := os.OpenFile((*f).Name(), os.O_RDWR|os.O_SYNC, 0644)
+			if err != nil {
+				return err
+			}
+			(*f).Close()
+			*f = reopened
+		}
+		switch name {
+		case FeedsFile:
+			err = db.loadFeeds()
+		case ItemsFile:
+			if same && fi.Size() > old.Size() {
+				err = db.loadItems(old.Size())
+			} else {
+				err = db.loadItems(0)
+			}
+		case SeenFile:
+			err = db.loadSeen()
+		case RulesFile:
+			err = db.loadRules()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reload loads the changes other processes sharing the directory
+// made since the files were last loaded or written.
+func (db *DB) Reload() error {
+	if err := db.lock(); err != nil {
+		return err
+	}
+	db.unlock()
+	return nil
+}
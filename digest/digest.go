@@ -0,0 +1,458 @@
+// Package digest emails summaries of the unread items on a daily
+// or weekly schedule.
+package digest
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/erikfastermann/feeder/db"
+	"github.com/erikfastermann/feeder/internal/worker"
+	"github.com/erikfastermann/feeder/notify"
+)
+
+// Schedules of a digest.
+const (
+	Daily  = "daily"
+	Weekly = "weekly"
+)
+
+const (
+	// StateFile in the directory passed to Open keeps
+	// when the digests were sent.
+	StateFile = "digest.csv"
+
+	DefaultAt       = "07:00"
+	DefaultMaxItems = 200
+	// DefaultRetry is how long to wait before sending a digest
+	// again after it failed.
+	DefaultRetry = 15 * time.Minute
+
+	checkInterval = time.Minute
+)
+
+var timeNow = time.Now
+
+// Digest is an email with the items added since the last one. Items are
+// included if they are unread, or IncludeRead is set, and if they are
+// from one of the Feeds or have one of the Tags set by rules.
+// Without Feeds and Tags all items are included.
+type Digest struct {
+	Name string      `json:"name"`
+	To   []string    `json:"to"`
+	SMTP notify.SMTP `json:"smtp"`
+
+	// Every is daily or weekly. Digests are sent At a time of the day,
+	// HH:MM, in the TimeZone, an IANA name like Europe/Berlin,
+	// by default the local time zone. Weekly digests are sent
+	// on the Weekday, by default on Monday.
+	Every    string `json:"every"`
+	At       string `json:"at,omitempty"`
+	Weekday  string `json:"weekday,omitempty"`
+	TimeZone string `json:"time_zone,omitempty"`
+
+	Feeds       []int    `json:"feeds,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	IncludeRead bool     `json:"include_read,omitempty"`
+	// MaxItems limits the listed items, the newest are listed.
+	MaxItems int `json:"max_items,omitempty"`
+
+	loc          *time.Location
+	hour, minute int
+	weekday      time.Weekday
+}
+
+// parse validates d and sets the defaults.
+func (d *Digest) parse() error {
+	if d.Name == "" {
+		return errors.New("digest: digest without a name")
+	}
+	errorf := func(format string, a ...interface{}) error {
+		return fmt.Errorf("digest %s: "+format, append([]interface{}{d.Name}, a...)...)
+	}
+	if d.SMTP.Addr == "" || d.SMTP.From == "" || len(d.To) == 0 {
+		return errorf("needs smtp.addr, smtp.from and to")
+	}
+	if d.Every != Daily && d.Every != Weekly {
+		return errorf("every must be %s or %s, not %q", Daily, Weekly, d.Every)
+	}
+	if d.At == "" {
+		d.At = DefaultAt
+	}
+	at, err := time.Parse("15:04", d.At)
+	if err != nil {
+		return errorf("invalid time %q, want HH:MM", d.At)
+	}
+	d.hour, d.minute = at.Hour(), at.Minute()
+	d.weekday = time.Monday
+	if d.Weekday != "" {
+		if d.Every != Weekly {
+			return errorf("weekday is only used by weekly digests")
+		}
+		ok := false
+		for wd := time.Sunday; wd <= time.Saturday; wd++ {
+			if strings.EqualFold(d.Weekday, wd.String()) {
+				d.weekday, ok = wd, true
+			}
+		}
+		if !ok {
+			return errorf("unknown weekday %q", d.Weekday)
+		}
+	}
+	d.loc = time.Local
+	if d.TimeZone != "" {
+		if d.loc, err = time.LoadLocation(d.TimeZone); err != nil {
+			return errorf("%v", err)
+		}
+	}
+	if d.MaxItems < 0 {
+		return errorf("max_items must not be negative")
+	}
+	if d.MaxItems == 0 {
+		d.MaxItems = DefaultMaxItems
+	}
+	return nil
+}
+
+// next returns the first time the digest is sent after t.
+func (d Digest) next(t time.Time) time.Time {
+	local := t.In(d.loc)
+	y, m, day := local.Date()
+	if d.Every == Weekly {
+		day += (int(d.weekday) - int(local.Weekday()) + 7) % 7
+	}
+	next := time.Date(y, m, day, d.hour, d.minute, 0, 0, d.loc)
+	if !next.After(t) {
+		days := 1
+		if d.Every == Weekly {
+			days = 7
+		}
+		next = time.Date(y, m, day+days, d.hour, d.minute, 0, 0, d.loc)
+	}
+	return next
+}
+
+func (d Digest) wants(item db.Item) bool {
+	if item.Read && !d.IncludeRead {
+		return false
+	}
+	if len(d.Feeds) == 0 && len(d.Tags) == 0 {
+		return true
+	}
+	for _, id := range d.Feeds {
+		if id == item.FeedID {
+			return true
+		}
+	}
+	for _, tag := range d.Tags {
+		for _, itemTag := range item.Tags {
+			if tag == itemTag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// LoadDigests reads a JSON array of digests from path.
+func LoadDigests(path string) ([]Digest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var digests []Digest
+	if err := json.Unmarshal(data, &digests); err != nil {
+		return nil, fmt.Errorf("digest: %s: %v", path, err)
+	}
+	names := make(map[string]bool)
+	for i := range digests {
+		if err := digests[i].parse(); err != nil {
+			return nil, err
+		}
+		if names[digests[i].Name] {
+			return nil, fmt.Errorf("digest: duplicate digest %s", digests[i].Name)
+		}
+		names[digests[i].Name] = true
+	}
+	return digests, nil
+}
+
+// State is when a digest was sent.
+type State struct {
+	Name string
+	// Sent is the time of the last digest and LastItem the ID
+	// of the last item added before it. The next digest
+	// has the items added after LastItem.
+	Sent     time.Time
+	LastItem int
+	// Attempt and Err are set if sending the next digest failed.
+	Attempt time.Time
+	Err     string
+}
+
+const (
+	sName     = 0
+	sSent     = 1
+	sLastItem = 2
+	sAttempt  = 3
+	sErr      = 4
+	sLen      = 5
+)
+
+// Mailer sends the Digests on their schedules.
+type Mailer struct {
+	Logger  *log.Logger
+	DB      *db.DB
+	Digests []Digest
+	// URL, if set, is linked in the digests to open feeder.
+	URL   string
+	Retry time.Duration
+
+	file *os.File
+
+	initOnce sync.Once
+	workers  worker.Group
+
+	mu    sync.Mutex
+	state map[string]*State
+}
+
+// Open returns a Mailer that keeps its state in dir.
+func Open(dir string) (*Mailer, error) {
+	f, err := os.OpenFile(filepath.Join(dir, StateFile), os.O_RDWR|os.O_CREATE|os.O_SYNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	m := &Mailer{file: f, state: make(map[string]*State)}
+	if err := m.load(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("digest: %s: %v", StateFile, err)
+	}
+	return m, nil
+}
+
+func (m *Mailer) load() error {
+	date := func(str string) (time.Time, error) {
+		if str == "" {
+			return time.Time{}, nil
+		}
+		return time.Parse(time.RFC3339, str)
+	}
+
+	recs, err := csv.NewReader(m.file).ReadAll()
+	if err != nil {
+		return err
+	}
+	for _, r := range recs {
+		if len(r) != sLen {
+			return errors.New("unexpected row length")
+		}
+		s := &State{Name: r[sName], Err: r[sErr]}
+		if s.Sent, err = date(r[sSent]); err != nil {
+			return err
+		}
+		if s.LastItem, err = strconv.Atoi(r[sLastItem]); err != nil {
+			return err
+		}
+		if s.Attempt, err = date(r[sAttempt]); err != nil {
+			return err
+		}
+		m.state[s.Name] = s
+	}
+	return nil
+}
+
+// save replaces the state file, dropping the state of removed digests.
+// The caller must hold m.mu.
+func (m *Mailer) save() error {
+	date := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+
+	recs := make([][]string, 0, len(m.Digests))
+	for _, d := range m.Digests {
+		s, ok := m.state[d.Name]
+		if !ok {
+			continue
+		}
+		r := make([]string, sLen)
+		r[sName] = s.Name
+		r[sSent] = date(s.Sent)
+		r[sLastItem] = strconv.Itoa(s.LastItem)
+		r[sAttempt] = date(s.Attempt)
+		r[sErr] = s.Err
+		recs = append(recs, r)
+	}
+
+	// Write a temporary file and rename it, so a failed write
+	// keeps the old state.
+	name := m.file.Name()
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	err = csv.NewWriter(tmp).WriteAll(recs)
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_SYNC, 0644)
+	if err != nil {
+		return err
+	}
+	m.file.Close()
+	m.file = f
+	return nil
+}
+
+// States returns the state of the digests that were sent
+// or started, sorted by name.
+func (m *Mailer) States() []State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]State, 0, len(m.state))
+	for _, s := range m.state {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (m *Mailer) init() {
+	m.initOnce.Do(func() {
+		if m.Logger == nil {
+			m.Logger = log.New(ioutil.Discard, "", 0)
+		}
+		if m.Retry <= 0 {
+			m.Retry = DefaultRetry
+		}
+	})
+}
+
+// Start sends the digests when they are due until Shutdown is called.
+func (m *Mailer) Start() {
+	m.init()
+	m.workers.Go(m.loop)
+}
+
+func (m *Mailer) loop() {
+	t := time.NewTicker(checkInterval)
+	defer t.Stop()
+	for {
+		m.check(m.workers.Context())
+		select {
+		case <-m.workers.Stopping():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// check sends the digests that are due. A new digest starts with
+// the items added after the first check, instead of all items.
+func (m *Mailer) check(ctx context.Context) {
+	m.init()
+	now := timeNow()
+	for _, d := range m.Digests {
+		m.mu.Lock()
+		s, ok := m.state[d.Name]
+		if ok {
+			s := *s
+			m.mu.Unlock()
+			if d.next(s.Sent).After(now) || (s.Err != "" && now.Sub(s.Attempt) < m.Retry) {
+				continue
+			}
+			m.send(ctx, d, s, now)
+			continue
+		}
+		last, err := m.DB.LastItemID()
+		if err == nil {
+			m.state[d.Name] = &State{Name: d.Name, Sent: now, LastItem: last}
+			err = m.save()
+		}
+		m.mu.Unlock()
+		if err != nil {
+			m.Logger.Printf("digest %s: failed starting, %v", d.Name, err)
+		}
+	}
+}
+
+func (m *Mailer) send(ctx context.Context, d Digest, s State, now time.Time) {
+	var last int
+	err := func() error {
+		var err error
+		if last, err = m.DB.LastItemID(); err != nil {
+			return err
+		}
+		all, err := m.DB.ItemsSince(s.LastItem)
+		if err != nil {
+			return err
+		}
+		items := make([]db.ItemWithHost, 0)
+		for _, item := range all {
+			if item.ID <= last && d.wants(item.Item) {
+				items = append(items, item)
+			}
+		}
+		if len(items) == 0 {
+			m.Logger.Printf("digest %s: no items, not sending", d.Name)
+			return nil
+		}
+		subject, text, html, err := render(d, items, m.URL)
+		if err != nil {
+			return err
+		}
+		return d.SMTP.SendHTML(ctx, d.To, subject, text, html)
+	}()
+
+	if err != nil {
+		m.Logger.Printf("digest %s: failed sending, %v", d.Name, err)
+		s.Attempt, s.Err = now, err.Error()
+	} else {
+		s.Sent, s.LastItem, s.Attempt, s.Err = now, last, time.Time{}, ""
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state[d.Name] = &s
+	if err := m.save(); err != nil {
+		m.Logger.Printf("digest %s: failed saving the state, %v", d.Name, err)
+	}
+}
+
+// Shutdown stops sending digests and waits for the running one,
+// unless ctx expires first. The state file is closed.
+func (m *Mailer) Shutdown(ctx context.Context) error {
+	err := m.workers.Shutdown(ctx)
+	m.mu.Lock()
+	m.file.Close()
+	m.mu.Unlock()
+	return err
+}
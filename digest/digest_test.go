@@ -0,0 +1,304 @@
+package digest
+
+import (
+	"context"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/erikfastermann/feeder/db"
+	"github.com/erikfastermann/feeder/internal/smtptest"
+	"github.com/erikfastermann/feeder/notify"
+)
+
+// parts returns the subject and the decoded text and HTML parts of msg.
+func parts(t *testing.T, msg string) (string, string, string) {
+	m, err := mail.ReadMessage(strings.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("got content type %q, %v", m.Header.Get("Content-Type"), err)
+	}
+	bodies := make(map[string]string)
+	r := multipart.NewReader(m.Body, params["boundary"])
+	for {
+		p, err := r.NextRawPart()
+		if err != nil {
+			break
+		}
+		body, err := ioutil.ReadAll(quotedprintable.NewReader(p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mediaType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		bodies[mediaType] = string(body)
+	}
+	return subject, bodies["text/plain"], bodies["text/html"]
+}
+
+func mustParse(t *testing.T, d Digest) Digest {
+	if err := d.parse(); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	smtp := notify.SMTP{Addr: "localhost:25", From: "feeder@example.com"}
+	to := []string{"jane@example.com"}
+	for _, tc := range []struct {
+		d     Digest
+		after time.Time
+		want  time.Time
+	}{
+		{
+			d:     Digest{Every: Daily, At: "07:30", TimeZone: "Europe/Berlin"},
+			after: time.Date(2026, 10, 19, 5, 0, 0, 0, time.UTC),
+			want:  time.Date(2026, 10, 19, 5, 30, 0, 0, time.UTC),
+		},
+		{
+			// 07:30 in Berlin already passed.
+			d:     Digest{Every: Daily, At: "07:30", TimeZone: "Europe/Berlin"},
+			after: time.Date(2026, 10, 19, 5, 30, 0, 0, time.UTC),
+			want:  time.Date(2026, 10, 20, 5, 30, 0, 0, time.UTC),
+		},
+		{
+			// Daylight saving time ends on October 25.
+			d:     Digest{Every: Daily, At: "07:30", TimeZone: "Europe/Berlin"},
+			after: time.Date(2026, 10, 24, 6, 0, 0, 0, time.UTC),
+			want:  time.Date(2026, 10, 25, 6, 30, 0, 0, time.UTC),
+		},
+		{
+			// Sunday night in Berlin is still the afternoon in New York.
+			d:     Digest{Every: Weekly, At: "18:00", Weekday: "sunday", TimeZone: "America/New_York"},
+			after: time.Date(2026, 10, 18, 23, 0, 0, 0, berlin),
+			want:  time.Date(2026, 10, 18, 22, 0, 0, 0, time.UTC),
+		},
+		{
+			d:     Digest{Every: Weekly, TimeZone: "UTC"},
+			after: time.Date(2026, 10, 19, 7, 0, 0, 0, time.UTC),
+			want:  time.Date(2026, 10, 26, 7, 0, 0, 0, time.UTC),
+		},
+	} {
+		tc.d.Name, tc.d.SMTP, tc.d.To = "test", smtp, to
+		d := mustParse(t, tc.d)
+		if got := d.next(tc.after); !got.Equal(tc.want) {
+			t.Errorf("%+v after %v: got %v, want %v", tc.d, tc.after, got.UTC(), tc.want)
+		}
+	}
+}
+
+func TestLoadDigests(t *testing.T) {
+	dir := t.TempDir()
+	const smtp = `"smtp": {"addr": "localhost:25", "from": "feeder@example.com"}, "to": ["a@example.com"]`
+	for _, tc := range []struct {
+		config string
+		ok     bool
+	}{
+		{`[{"name": "a", "every": "daily", ` + smtp + `}]`, true},
+		{`[{"name": "a", "every": "weekly", "weekday": "Friday", "at": "17:00", "time_zone": "Asia/Tokyo", ` + smtp + `}]`, true},
+		{`[{"name": "a", "every": "hourly", ` + smtp + `}]`, false},
+		{`[{"name": "a", "every": "daily", "at": "7am", ` + smtp + `}]`, false},
+		{`[{"name": "a", "every": "daily", "weekday": "monday", ` + smtp + `}]`, false},
+		{`[{"name": "a", "every": "weekly", "weekday": "someday", ` + smtp + `}]`, false},
+		{`[{"name": "a", "every": "daily", "time_zone": "Mars/Olympus", ` + smtp + `}]`, false},
+		{`[{"name": "a", "every": "daily", "to": ["a@example.com"]}]`, false},
+		{`[{"name": "a", "every": "daily", ` + smtp + `}, {"name": "a", "every": "weekly", ` + smtp + `}]`, false},
+	} {
+		path := dir + "/digests.json"
+		if err := ioutil.WriteFile(path, []byte(tc.config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadDigests(path); (err == nil) != tc.ok {
+			t.Errorf("%s: got error %v", tc.config, err)
+		}
+	}
+}
+
+func TestMailer(t *testing.T) {
+	dir := t.TempDir()
+	d, err := db.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	for _, name := range []string{"Zeta", "Alpha", "Other"} {
+		id, err := d.AddFeed("https://example.com", "https://example.com/"+name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.EditFeedName(id, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add := func(feedID int, titles ...string) {
+		items := make([]db.Item, 0)
+		for _, title := range titles {
+			items = append(items, db.Item{Title: title, URL: "https://example.com/" + title, Added: time.Now()})
+		}
+		if _, err := d.AddItems(feedID, items); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add(1, "old")
+
+	s := smtptest.NewServer(t)
+	m, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.DB = d
+	m.URL = "https://feeder.example.com"
+	m.Digests = []Digest{mustParse(t, Digest{
+		Name:     "Morning",
+		To:       []string{"jane@example.com"},
+		SMTP:     notify.SMTP{Addr: s.Addr, From: "feeder@example.com"},
+		Every:    Daily,
+		At:       "07:00",
+		TimeZone: "UTC",
+		Feeds:    []int{1, 2},
+	})}
+	ctx := context.Background()
+
+	// The first check starts the digest without sending the old items.
+	start := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	now := start
+	defer func(f func() time.Time) { timeNow = f }(timeNow)
+	timeNow = func() time.Time { return now }
+	m.check(ctx)
+	add(1, "zeta <1>", "zeta 2")
+	add(2, "alpha 1", "alpha read")
+	add(3, "filtered")
+	items, err := d.ItemsSince(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range items {
+		if item.Title == "alpha read" {
+			if err := d.SetRead(item.ID, true); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	now = start.Add(18*time.Hour + 59*time.Minute)
+	m.check(ctx)
+	if got := s.Messages(); len(got) != 0 {
+		t.Fatalf("got %d messages before the schedule", len(got))
+	}
+
+	s.SetFail(true)
+	sendAt := start.Add(19 * time.Hour)
+	now = sendAt
+	m.check(ctx)
+	if st := m.States(); len(st) != 1 || !strings.Contains(st[0].Err, "451") || !st[0].Attempt.Equal(sendAt) {
+		t.Fatalf("got states %+v", st)
+	}
+	s.SetFail(false)
+	now = sendAt.Add(DefaultRetry - time.Second)
+	m.check(ctx)
+	if got := s.Messages(); len(got) != 0 {
+		t.Fatalf("got %d messages before the retry", len(got))
+	}
+	sendAt = sendAt.Add(DefaultRetry)
+	now = sendAt
+	m.check(ctx)
+	got := s.Messages()
+	if len(got) != 1 {
+		t.Fatalf("got %d messages, want 1", len(got))
+	}
+
+	subject, text, html := parts(t, got[0])
+	if subject != "Morning: 3 unread items" {
+		t.Errorf("got subject %q", subject)
+	}
+	for _, want := range []string{"zeta <1>", "https://example.com/alpha 1", "Open feeder: https://feeder.example.com"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("text without %q\n%s", want, text)
+		}
+	}
+	if strings.Index(text, "Alpha") > strings.Index(text, "Zeta") {
+		t.Errorf("feeds not sorted by name\n%s", text)
+	}
+	for _, want := range []string{"<h2", "zeta &lt;1&gt;", `href="https://example.com/zeta%202"`} {
+		if !strings.Contains(html, want) {
+			t.Errorf("HTML without %q\n%s", want, html)
+		}
+	}
+	for _, body := range []string{text, html} {
+		for _, unwanted := range []string{"old", "alpha read", "filtered"} {
+			if strings.Contains(body, unwanted) {
+				t.Errorf("message with %q\n%s", unwanted, body)
+			}
+		}
+	}
+
+	// The next digest only has the items added since.
+	if err := m.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	m, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.DB = d
+	m.Digests = []Digest{mustParse(t, Digest{
+		Name:     "Morning",
+		To:       []string{"jane@example.com"},
+		SMTP:     notify.SMTP{Addr: s.Addr, From: "feeder@example.com"},
+		Every:    Daily,
+		TimeZone: "UTC",
+	})}
+	defer m.Shutdown(ctx)
+	if st := m.States(); len(st) != 1 || !st[0].Sent.Equal(sendAt) || st[0].Err != "" {
+		t.Fatalf("got states %+v after reopening", st)
+	}
+	add(3, "next")
+	now = sendAt.Add(24 * time.Hour)
+	m.check(ctx)
+	got = s.Messages()
+	if len(got) != 2 {
+		t.Fatalf("got %d messages, want 2", len(got))
+	}
+	if subject, text, _ := parts(t, got[1]); subject != "Morning: 1 unread item" || !strings.Contains(text, "next") || strings.Contains(text, "zeta") {
+		t.Errorf("got %q\n%s", subject, text)
+	}
+}
+
+func TestMaxItems(t *testing.T) {
+	d := mustParse(t, Digest{
+		Name:     "test",
+		To:       []string{"jane@example.com"},
+		SMTP:     notify.SMTP{Addr: "localhost:25", From: "feeder@example.com"},
+		Every:    Daily,
+		MaxItems: 2,
+	})
+	items := []db.ItemWithHost{
+		{Item: db.Item{FeedID: 1, Title: "3"}, FeedName: "a"},
+		{Item: db.Item{FeedID: 2, Title: "2"}, FeedName: "b"},
+		{Item: db.Item{FeedID: 1, Title: "1"}, FeedName: "a"},
+	}
+	subject, text, _, err := render(d, items, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "test: 3 unread items" || !strings.Contains(text, "and 1 more") ||
+		!strings.Contains(text, "- 2") || strings.Contains(text, "- 1") || strings.Contains(text, "Open feeder") {
+		t.Errorf("got %q\n%s", subject, text)
+	}
+}
@@ -0,0 +1,102 @@
+package digest
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/erikfastermann/feeder/db"
+)
+
+const textTemplate = `{{ .Title }}
+{{ range .Feeds }}
+{{ .Name }}
+{{ range .Items }}
+- {{ .Title }}
+  {{ .URL }}
+{{ end }}{{ end }}{{ if .More }}
+and {{ .More }} more
+{{ end }}{{ if .URL }}
+Open feeder: {{ .URL }}
+{{ end }}`
+
+const htmlTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ .Title }}</title>
+</head>
+<body style="font-family: sans-serif; max-width: 40em;">
+<h1 style="font-size: 1.3em;">{{ .Title }}</h1>
+{{ range .Feeds }}<h2 style="font-size: 1.1em; margin-bottom: 0.3em;">{{ .Name }}</h2>
+<ul style="margin-top: 0;">
+{{ range .Items }}<li><a href="{{ .URL }}">{{ .Title }}</a> <small>{{ .Added.Format "Jan 2, 15:04" }}</small></li>
+{{ end }}</ul>
+{{ end }}{{ if .More }}<p>and {{ .More }} more</p>
+{{ end }}{{ if .URL }}<p><a href="{{ .URL }}">Open feeder</a></p>
+{{ end }}</body>
+</html>
+`
+
+var (
+	textTmplt = texttemplate.Must(texttemplate.New("text").Parse(textTemplate))
+	htmlTmplt = htmltemplate.Must(htmltemplate.New("html").Parse(htmlTemplate))
+)
+
+type feedGroup struct {
+	Name  string
+	Items []db.Item
+}
+
+// render returns the subject and the text and HTML bodies of a digest
+// with the items, newest first. The listed items are grouped by feed.
+func render(d Digest, items []db.ItemWithHost, url string) (string, string, string, error) {
+	kind := "unread"
+	if d.IncludeRead {
+		kind = "new"
+	}
+	noun := "items"
+	if len(items) == 1 {
+		noun = "item"
+	}
+	title := fmt.Sprintf("%s: %d %s %s", d.Name, len(items), kind, noun)
+
+	more := 0
+	if len(items) > d.MaxItems {
+		more = len(items) - d.MaxItems
+		items = items[:d.MaxItems]
+	}
+	groups := make([]*feedGroup, 0)
+	byFeed := make(map[int]*feedGroup)
+	for _, item := range items {
+		g, ok := byFeed[item.FeedID]
+		if !ok {
+			g = &feedGroup{Name: item.FeedName}
+			byFeed[item.FeedID] = g
+			groups = append(groups, g)
+		}
+		item.Added = item.Added.In(d.loc)
+		g.Items = append(g.Items, item.Item)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return strings.ToLower(groups[i].Name) < strings.ToLower(groups[j].Name)
+	})
+
+	data := struct {
+		Title string
+		Feeds []*feedGroup
+		More  int
+		URL   string
+	}{title, groups, more, url}
+	var text, html bytes.Buffer
+	if err := textTmplt.Execute(&text, data); err != nil {
+		return "", "", "", err
+	}
+	if err := htmlTmplt.Execute(&html, data); err != nil {
+		return "", "", "", err
+	}
+	return title, text.String(), html.String(), nil
+}
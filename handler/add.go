@@ -1,20 +1,30 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/erikfastermann/feeder/parser"
+	"github.com/erikfastermann/feeder/rules"
 )
 
 func (h *Handler) addFeed(w http.ResponseWriter, r *http.Request) error {
 	feedURL := r.FormValue("url")
 
-	items, err := parser.Parse(feedURL)
+	feed, err := h.Parser.Parse(r.Context(), feedURL)
 	if err != nil {
+		var blocked *parser.BlockedError
+		if errors.As(err, &blocked) {
+			return badRequestf("add: invalid feed URL %s, %v", feedURL, blocked)
+		}
 		return badRequestf("add: failed parsing feed %s, %v", feedURL, err)
 	}
 
+	if feed.PermanentURL != "" {
+		feedURL = feed.PermanentURL
+	}
 	url, err := url.Parse(feedURL)
 	if err != nil {
 		return err
@@ -23,7 +33,21 @@ func (h *Handler) addFeed(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
-	if err := h.DB.AddItems(id, items); err != nil {
+	if err := h.DB.SetFeedMeta(id, feed.FeedMeta); err != nil {
+		return err
+	}
+	if err := h.DB.SetFeedSkipped(id, feed.Skipped); err != nil {
+		return err
+	}
+	set, invalid, err := rules.Load(h.DB)
+	if err != nil {
+		return err
+	}
+	for _, err := range invalid {
+		h.Logger.Printf("skipping invalid %v", err)
+	}
+	items, _ := set.Apply(id, feed, time.Now())
+	if _, err := h.DB.AddItems(id, items); err != nil {
 		return err
 	}
 
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/erikfastermann/feeder/db"
+	"github.com/erikfastermann/httpwrap"
+)
+
+const (
+	routeAPIRefresh = "/refresh"
+	routeAPIItems   = "/items"
+)
+
+func (h *Handler) api(w http.ResponseWriter, r *http.Request) error {
+	split := strings.Split(path.Clean(r.URL.Path), "/")
+	route := ""
+	if len(split) > 2 {
+		route = "/" + split[2]
+	}
+
+	switch route {
+	case routeAPIRefresh:
+		return h.apiRefresh(w, r)
+	case routeAPIItems:
+		return h.apiItems(w, r)
+	default:
+		return httpwrap.Error{
+			StatusCode: http.StatusNotFound,
+			Err:        fmt.Errorf("api: invalid URL %s", r.URL.Path),
+		}
+	}
+}
+
+// jsonItem is an item as the API and the event stream send it.
+type jsonItem struct {
+	ID       int       `json:"id"`
+	FeedID   int       `json:"feed_id"`
+	Host     string    `json:"host"`
+	FeedName string    `json:"feed_name"`
+	Title    string    `json:"title"`
+	URL      string    `json:"url"`
+	Added    time.Time `json:"added"`
+	Read     bool      `json:"read"`
+	Starred  bool      `json:"starred"`
+	Tags     []string  `json:"tags,omitempty"`
+}
+
+func newJSONItem(i db.ItemWithHost) jsonItem {
+	return jsonItem{
+		ID:       i.ID,
+		FeedID:   i.FeedID,
+		Host:     i.Host,
+		FeedName: i.FeedName,
+		Title:    i.Title,
+		URL:      i.URL,
+		Added:    i.Added,
+		Read:     i.Read,
+		Starred:  i.Starred,
+		Tags:     i.Tags,
+	}
+}
+
+func (h *Handler) apiItems(w http.ResponseWriter, r *http.Request) error {
+	feedID := 0
+	if idStr := r.FormValue("feed"); idStr != "" {
+		var err error
+		feedID, err = strconv.Atoi(idStr)
+		if err != nil {
+			return badRequestf("%s is an invalid feed id, %v", strconv.Quote(idStr), err)
+		}
+	}
+	p, err := h.itemsPage(r, feedID)
+	if err != nil {
+		return err
+	}
+
+	out := struct {
+		Items []jsonItem `json:"items"`
+		Newer string     `json:"newer,omitempty"`
+		Older string     `json:"older,omitempty"`
+	}{Items: make([]jsonItem, 0)}
+	for _, i := range p.Items {
+		out.Items = append(out.Items, newJSONItem(i))
+	}
+	if p.Newer != nil {
+		out.Newer = p.Newer.String()
+	}
+	if p.Older != nil {
+		out.Older = p.Older.String()
+	}
+	return writeJSON(w, out)
+}
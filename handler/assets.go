@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"errors"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"sort"
+)
+
+const (
+	templateGlob = "template/*.html"
+	staticDir    = "static"
+)
+
+// overlayFS serves files from upper if they exist there and falls back to
+// lower otherwise. Directory listings are merged.
+type overlayFS struct {
+	upper, lower fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.upper.Open(name)
+	if err == nil {
+		return f, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return o.lower.Open(name)
+}
+
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	upper, err := fs.ReadDir(o.upper, name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	lower, err := fs.ReadDir(o.lower, name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if upper == nil && lower == nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	m := make(map[string]fs.DirEntry)
+	for _, e := range lower {
+		m[e.Name()] = e
+	}
+	for _, e := range upper {
+		m[e.Name()] = e
+	}
+	entries := make([]fs.DirEntry, 0, len(m))
+	for _, e := range m {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func (h *Handler) initAssets() error {
+	if h.Assets == nil {
+		return errors.New("handler: no assets configured")
+	}
+	h.assets = h.Assets
+	if h.OverrideDir != "" {
+		fi, err := os.Stat(h.OverrideDir)
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return errors.New("handler: override " + h.OverrideDir + " is not a directory")
+		}
+		h.assets = overlayFS{upper: os.DirFS(h.OverrideDir), lower: h.Assets}
+	}
+
+	static, err := fs.Sub(h.assets, staticDir)
+	if err != nil {
+		return err
+	}
+	h.static = http.StripPrefix(routeStatic+"/", http.FileServer(http.FS(static)))
+
+	h.tmplts, err = h.parseTemplates()
+	return err
+}
+
+func (h *Handler) parseTemplates() (*template.Template, error) {
+	funcs := template.FuncMap{
+		"hasIcon": func(feedID int) bool {
+			return h.Icons != nil && h.Icons.Has(feedID)
+		},
+	}
+	return template.New("").Funcs(funcs).ParseFS(h.assets, templateGlob)
+}
+
+// templates returns the parsed templates. In dev mode they are parsed
+// again on every call, so edits in the override directory show up on reload.
+func (h *Handler) templates() (*template.Template, error) {
+	if !h.Dev {
+		return h.tmplts, nil
+	}
+	return h.parseTemplates()
+}
+
+func (h *Handler) render(w http.ResponseWriter, name string, data interface{}) error {
+	tmplts, err := h.templates()
+	if err != nil {
+		return err
+	}
+	contentTypeHTML(w)
+	return tmplts.ExecuteTemplate(w, name, data)
+}
@@ -12,9 +12,9 @@ func (h *Handler) edit(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return badRequestf("%s is an invalid id, %v", strconv.Quote(idStr), err)
 	}
-	host := r.FormValue("host")
+	name := r.FormValue("name")
 
-	if err := h.DB.EditFeedHost(id, host); err != nil {
+	if err := h.DB.EditFeedName(id, name); err != nil {
 		if err == sql.ErrNoRows {
 			return badRequestf("id %d not found in db, %v", id, err)
 		}
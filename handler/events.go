@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/erikfastermann/feeder/db"
+	"github.com/erikfastermann/feeder/updater"
+)
+
+const (
+	// eventsHeartbeat keeps idle streams from being closed by proxies.
+	eventsHeartbeat = 30 * time.Second
+	// eventsRetry is how long clients wait before they reconnect.
+	eventsRetry = 10 * time.Second
+)
+
+// events streams Server-Sent Events for every feed that is fetched or
+// pushed: a feed event with its status and, if it has new items,
+// an items event with them.
+func (h *Handler) events(w http.ResponseWriter, r *http.Request) error {
+	results, stop := h.Updater.Listen()
+	defer stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Disables the buffering of nginx.
+	w.Header().Set("X-Accel-Buffering", "no")
+	rc := http.NewResponseController(w)
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", eventsRetry.Milliseconds()); err != nil {
+		return nil
+	}
+	if err := rc.Flush(); err != nil {
+		return fmt.Errorf("events: can't stream, %v", err)
+	}
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-h.closed:
+			return nil
+		case <-heartbeat.C:
+			_, err = io.WriteString(w, ": ping\n\n")
+		case res := <-results:
+			err = h.writeEvents(w, res)
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			// The client is gone, nothing can be sent anymore.
+			return nil
+		}
+	}
+}
+
+func (h *Handler) writeEvents(w io.Writer, res updater.Result) error {
+	feed, err := h.DB.Feed(res.Feed.ID)
+	if err != nil {
+		// The feed was removed meanwhile.
+		return nil
+	}
+
+	status := struct {
+		ID          int        `json:"id"`
+		Name        string     `json:"name"`
+		LastChecked *time.Time `json:"last_checked,omitempty"`
+		Error       string     `json:"error,omitempty"`
+		NewItems    int        `json:"new_items"`
+	}{
+		ID:       feed.ID,
+		Name:     feed.DisplayName(),
+		Error:    feed.LastError,
+		NewItems: res.NewItems,
+	}
+	if feed.LastChecked.Valid {
+		status.LastChecked = &feed.LastChecked.Time
+	}
+	if err := writeEvent(w, "feed", status); err != nil {
+		return err
+	}
+	if len(res.Items) == 0 {
+		return nil
+	}
+
+	items := make([]jsonItem, 0, len(res.Items))
+	for _, i := range res.Items {
+		items = append(items, newJSONItem(db.ItemWithHost{Item: i, Host: feed.Host, FeedName: feed.DisplayName()}))
+	}
+	return writeEvent(w, "items", struct {
+		Items []jsonItem `json:"items"`
+	}{items})
+}
+
+// writeEvent writes v as the JSON data of an event. JSON without
+// indentation has no newlines, so it fits into a single data line.
+func writeEvent(w io.Writer, name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+	return err
+}
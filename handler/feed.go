@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/erikfastermann/feeder/db"
+)
+
+// feed shows the items of a single feed, the id is part of the path.
+func (h *Handler) feed(w http.ResponseWriter, r *http.Request) error {
+	split := strings.Split(path.Clean(r.URL.Path), "/")
+	if len(split) != 3 {
+		return badRequestf("feed: missing id in %s", r.URL.Path)
+	}
+	id, err := strconv.Atoi(split[2])
+	if err != nil {
+		return badRequestf("%s is an invalid id, %v", strconv.Quote(split[2]), err)
+	}
+
+	feed, err := h.DB.Feed(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return badRequestf("id %d not found in db, %v", id, err)
+		}
+		return err
+	}
+	p, err := h.itemsPage(r, id)
+	if err != nil {
+		return err
+	}
+
+	return h.render(w, "feed.html", struct {
+		Feed db.Feed
+		db.Page
+		Limit string
+	}{feed, p, r.FormValue("limit")})
+}
+
+// readAll marks all items of the feed given by id as read.
+func (h *Handler) readAll(w http.ResponseWriter, r *http.Request) error {
+	idStr := r.FormValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return badRequestf("%s is an invalid id, %v", strconv.Quote(idStr), err)
+	}
+
+	if err := h.DB.SetFeedRead(id); err != nil {
+		return err
+	}
+
+	redirectBack(w, r, routeFeed+"/"+strconv.Itoa(id))
+	return nil
+}
@@ -2,13 +2,26 @@ package handler
 
 import (
 	"net/http"
+
+	"github.com/erikfastermann/feeder/db"
 )
 
 func (h *Handler) feeds(w http.ResponseWriter, r *http.Request) error {
-	items, err := h.DB.AllFeeds()
+	feeds, err := h.DB.AllFeeds()
 	if err != nil {
 		return err
 	}
-	contentTypeHTML(w)
-	return h.tmplts.ExecuteTemplate(w, "feeds.html", items)
+
+	type data struct {
+		Feeds       []db.Feed
+		Refreshing  bool
+		Done, Total int
+	}
+	refreshing, done, total := h.Updater.Progress()
+	return h.render(w, "feeds.html", data{
+		Feeds:      feeds,
+		Refreshing: refreshing,
+		Done:       done,
+		Total:      total,
+	})
 }
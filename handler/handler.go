@@ -4,79 +4,164 @@ import (
 	"crypto/subtle"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"path"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/erikfastermann/feeder/db"
+	"github.com/erikfastermann/feeder/icon"
+	"github.com/erikfastermann/feeder/notify"
+	"github.com/erikfastermann/feeder/parser"
+	"github.com/erikfastermann/feeder/secret"
+	"github.com/erikfastermann/feeder/updater"
+	"github.com/erikfastermann/feeder/websub"
 	"github.com/erikfastermann/httpwrap"
 )
 
 const (
 	routeOverview = "/"
 	routeFeeds    = "/feeds"
+	routeFeed     = "/feed"
 	routeAdd      = "/add"
 	routeRemove   = "/remove"
 	routeEdit     = "/edit"
+	routeRefresh  = "/refresh"
+	routeRead     = "/read"
+	routeStar     = "/star"
+	routeReadAll  = "/readall"
+	routeOptions  = "/options"
+	routeRules    = "/rules"
+	routeNotify   = "/notify"
+	routeWebSub   = "/websub"
+	routeEvents   = "/events"
+	routeAPI      = "/api"
+	routeIcon     = "/icon"
+	routeStatic   = "/static"
 )
 
 type Handler struct {
-	once               sync.Once
 	Logger             *log.Logger
 	Username, Password string
-	TemplateGlob       string
-	tmplts             *template.Template
-	DB                 *db.DB
-}
 
-func (h *Handler) ServeHTTPWithErr(w http.ResponseWriter, r *http.Request) error {
-	h.once.Do(func() {
-		if h.Logger == nil {
-			h.Logger = log.New(ioutil.Discard, "", 0)
-		}
+	// Assets holds the default template/ and static/ directories.
+	// Files in OverrideDir, if set, take precedence over them.
+	Assets      fs.FS
+	OverrideDir string
+	// Dev re-parses the templates on every request.
+	Dev bool
+	// PageSize is the default number of items per page.
+	PageSize int
 
-		h.tmplts = template.Must(template.ParseGlob(h.TemplateGlob))
-		go func() {
-			h.update()
-			for range time.Tick(time.Hour) {
-				h.update()
-			}
-		}()
-	})
+	assets fs.FS
+	static http.Handler
+	tmplts *template.Template
 
-	user, pass, ok := r.BasicAuth()
-	userOk := subtle.ConstantTimeCompare([]byte(user), []byte(h.Username))
-	passOk := subtle.ConstantTimeCompare([]byte(pass), []byte(h.Password))
-	if !ok || userOk != 1 || passOk != 1 {
-		w.Header().Set("WWW-Authenticate", "Basic")
-		return httpwrap.Error{
-			StatusCode: http.StatusUnauthorized,
-			Err:        fmt.Errorf("router: invalid login credentials"),
-		}
+	// closed ends the event streams.
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	DB      *db.DB
+	Parser  *parser.Parser
+	Updater *updater.Updater
+	// Icons, if set, serves the cached icons of the feeds.
+	Icons *icon.Cache
+	// Secrets seals the fetch options of the feeds,
+	// they can't be edited if it is not set.
+	Secrets *secret.Box
+	// Notifier, if set, lists the notification targets and deliveries.
+	Notifier *notify.Notifier
+	// WebSub, if set, receives the callbacks of the hubs.
+	WebSub *websub.Subscriber
+}
+
+// Init validates the configuration and parses the templates.
+// It must be called before the Handler serves any requests.
+func (h *Handler) Init() error {
+	if h.Logger == nil {
+		h.Logger = log.New(ioutil.Discard, "", 0)
+	}
+	if h.PageSize <= 0 {
+		h.PageSize = DefaultPageSize
 	}
+	if h.Parser == nil {
+		h.Parser = &parser.Parser{}
+	}
+	h.closed = make(chan struct{})
+	return h.initAssets()
+}
+
+// CloseStreams ends the event streams, so that a graceful shutdown
+// of the server does not wait for them. New streams end immediately.
+func (h *Handler) CloseStreams() {
+	h.closeOnce.Do(func() {
+		close(h.closed)
+	})
+}
 
+func (h *Handler) ServeHTTPWithErr(w http.ResponseWriter, r *http.Request) error {
 	split := strings.Split(path.Clean(r.URL.Path), "/")
 	route := "/"
 	if len(split) > 1 {
 		route = "/" + split[1]
 	}
 
+	// Hubs can't log in, the callbacks check the signatures instead.
+	if route != routeWebSub {
+		user, pass, ok := r.BasicAuth()
+		userOk := subtle.ConstantTimeCompare([]byte(user), []byte(h.Username))
+		passOk := subtle.ConstantTimeCompare([]byte(pass), []byte(h.Password))
+		if !ok || userOk != 1 || passOk != 1 {
+			w.Header().Set("WWW-Authenticate", "Basic")
+			return httpwrap.Error{
+				StatusCode: http.StatusUnauthorized,
+				Err:        fmt.Errorf("router: invalid login credentials"),
+			}
+		}
+	}
+
 	var rt func(http.ResponseWriter, *http.Request) error
 	switch route {
 	case routeOverview:
 		rt = h.overview
 	case routeFeeds:
 		rt = h.feeds
+	case routeFeed:
+		rt = h.feed
 	case routeAdd:
 		rt = h.addFeed
 	case routeEdit:
 		rt = h.edit
 	case routeRemove:
 		rt = h.remove
+	case routeRefresh:
+		rt = h.refresh
+	case routeRead:
+		rt = h.read
+	case routeStar:
+		rt = h.star
+	case routeReadAll:
+		rt = h.readAll
+	case routeOptions:
+		rt = h.options
+	case routeRules:
+		rt = h.rulesPage
+	case routeNotify:
+		rt = h.notifications
+	case routeWebSub:
+		rt = h.websub
+	case routeEvents:
+		rt = h.events
+	case routeAPI:
+		rt = h.api
+	case routeIcon:
+		rt = h.icon
+	case routeStatic:
+		h.static.ServeHTTP(w, r)
+		return nil
 	default:
 		return httpwrap.Error{
 			StatusCode: http.StatusNotFound,
@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/erikfastermann/httpwrap"
+)
+
+// icon serves the cached icon of a feed, the id is part of the path.
+func (h *Handler) icon(w http.ResponseWriter, r *http.Request) error {
+	split := strings.Split(path.Clean(r.URL.Path), "/")
+	if len(split) != 3 {
+		return badRequestf("icon: missing id in %s", r.URL.Path)
+	}
+	id, err := strconv.Atoi(split[2])
+	if err != nil {
+		return badRequestf("%s is an invalid id, %v", strconv.Quote(split[2]), err)
+	}
+
+	notFound := httpwrap.Error{
+		StatusCode: http.StatusNotFound,
+		Err:        fmt.Errorf("icon: no icon for feed %d", id),
+	}
+	if h.Icons == nil {
+		return notFound
+	}
+	data, contentType, modTime, err := h.Icons.Get(id)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return notFound
+		}
+		return err
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	http.ServeContent(w, r, "", modTime, bytes.NewReader(data))
+	return nil
+}
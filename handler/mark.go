@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+)
+
+// read marks the item given by id as read, or as unread if undo is set.
+func (h *Handler) read(w http.ResponseWriter, r *http.Request) error {
+	return h.mark(w, r, h.DB.SetRead)
+}
+
+// star stars the item given by id, or unstars it if undo is set.
+func (h *Handler) star(w http.ResponseWriter, r *http.Request) error {
+	return h.mark(w, r, h.DB.SetStarred)
+}
+
+func (h *Handler) mark(w http.ResponseWriter, r *http.Request, set func(int, bool) error) error {
+	idStr := r.FormValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return badRequestf("%s is an invalid id, %v", strconv.Quote(idStr), err)
+	}
+
+	if err := set(id, r.FormValue("undo") == ""); err != nil {
+		if err == sql.ErrNoRows {
+			return badRequestf("item id %d not found in db, %v", id, err)
+		}
+		return err
+	}
+
+	redirectBack(w, r, routeOverview)
+	return nil
+}
+
+// redirectBack redirects to the referring page, or to fallback.
+func redirectBack(w http.ResponseWriter, r *http.Request, fallback string) {
+	to := r.Referer()
+	if to == "" {
+		to = fallback
+	}
+	http.Redirect(w, r, to, http.StatusSeeOther)
+}
@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/erikfastermann/feeder/notify"
+)
+
+// deliveriesShown is the number of deliveries on the notify page.
+const deliveriesShown = 50
+
+// notifications shows the notification targets and the latest deliveries.
+// POST with test set to the name of a target sends it a test item.
+func (h *Handler) notifications(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		if h.Notifier == nil {
+			return badRequestf("notify: no targets configured")
+		}
+		name := r.FormValue("test")
+		to := routeNotify
+		if err := h.Notifier.Test(r.Context(), name); err != nil {
+			to += "?" + url.Values{"error": {err.Error()}}.Encode()
+		}
+		http.Redirect(w, r, to, http.StatusSeeOther)
+		return nil
+	}
+
+	type data struct {
+		Enabled    bool
+		DryRun     bool
+		Targets    []notify.Target
+		Deliveries []notify.Delivery
+		Error      string
+	}
+	d := data{Error: r.FormValue("error")}
+	if h.Notifier != nil {
+		d.Enabled = true
+		d.DryRun = h.Notifier.DryRun
+		d.Targets = h.Notifier.Targets
+		d.Deliveries = h.Notifier.Deliveries(deliveriesShown)
+	}
+	return h.render(w, "notify.html", d)
+}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/erikfastermann/feeder/db"
+	"github.com/erikfastermann/feeder/parser"
+)
+
+// options shows and stores the fetch options of a feed. Passwords, tokens,
+// cookies and headers are never shown and neither is the password of the
+// proxy, empty fields keep the stored values.
+func (h *Handler) options(w http.ResponseWriter, r *http.Request) error {
+	idStr := r.FormValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return badRequestf("%s is an invalid id, %v", strconv.Quote(idStr), err)
+	}
+	feed, err := h.DB.Feed(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return badRequestf("id %d not found in db, %v", id, err)
+		}
+		return err
+	}
+
+	var opts parser.Options
+	if feed.Options != "" && h.Secrets != nil {
+		if err := h.Secrets.OpenJSON(feed.Options, &opts); err != nil {
+			return err
+		}
+	}
+
+	if r.Method != http.MethodPost {
+		return h.render(w, "options.html", struct {
+			Feed    db.Feed
+			Options parser.Options
+			Proxy   string
+			Enabled bool
+		}{feed, opts, redactedProxy(opts.Proxy), h.Secrets != nil})
+	}
+
+	if h.Secrets == nil {
+		return badRequestf("options: no secret key configured")
+	}
+	opts.Username = r.FormValue("username")
+	opts.UserAgent = r.FormValue("useragent")
+	if r.FormValue("clear") != "" {
+		opts.Password, opts.Token, opts.Cookie = "", "", ""
+		opts.Header, opts.Proxy = "", ""
+	}
+	for name, v := range map[string]*string{
+		"password": &opts.Password,
+		"token":    &opts.Token,
+		"cookie":   &opts.Cookie,
+		"header":   &opts.Header,
+		"proxy":    &opts.Proxy,
+	} {
+		if s := r.FormValue(name); s != "" {
+			*v = s
+		}
+	}
+	if err := opts.Validate(); err != nil {
+		return badRequestf("options: %v", err)
+	}
+
+	sealed := ""
+	if !opts.IsZero() {
+		if sealed, err = h.Secrets.SealJSON(opts); err != nil {
+			return err
+		}
+	}
+	if err := h.DB.SetFeedOptions(id, sealed); err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, routeFeeds, http.StatusSeeOther)
+	return nil
+}
+
+// redactedProxy returns the proxy URL without its password.
+func redactedProxy(proxy string) string {
+	if proxy == "" {
+		return ""
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return "unchanged"
+	}
+	return u.Redacted()
+}
@@ -8,51 +8,89 @@ import (
 	"github.com/erikfastermann/feeder/db"
 )
 
-func (h *Handler) overview(w http.ResponseWriter, r *http.Request) error {
-	const itemsPerPage = 30
-	page := uint(0)
-	if pageStr := r.FormValue("page"); pageStr != "" {
-		page64, err := strconv.ParseUint(pageStr, 10, 0)
-		page = uint(page64)
-		const uintMax = ^uint(0)
-		if err != nil || page > uintMax/itemsPerPage {
-			return badRequestf("overview: ivalid page %s", strconv.Quote(pageStr))
+const (
+	DefaultPageSize = 30
+	maxPageSize     = 500
+)
+
+// itemsPage returns the page of items of the feed, or of all feeds if
+// feedID is 0, selected by the before or after cursor and the optional
+// limit. The page number of old links is still supported as an offset
+// into the newest items of all feeds.
+func (h *Handler) itemsPage(r *http.Request, feedID int) (db.Page, error) {
+	q := db.PageQuery{FeedID: feedID, Limit: uint(h.PageSize)}
+	if limitStr := r.FormValue("limit"); limitStr != "" {
+		limit, err := strconv.ParseUint(limitStr, 10, 0)
+		if err != nil || limit == 0 || limit > maxPageSize {
+			return db.Page{}, badRequestf("invalid limit %s", strconv.Quote(limitStr))
 		}
+		q.Limit = uint(limit)
 	}
 
-	type data struct {
-		Prev  int
-		Next  int
-		Items []db.ItemWithHost
+	cursor := func(name string) (*db.Cursor, error) {
+		s := r.FormValue(name)
+		if s == "" {
+			return nil, nil
+		}
+		c, err := db.ParseCursor(s)
+		if err != nil {
+			return nil, badRequestf("invalid cursor %s, %v", strconv.Quote(s), err)
+		}
+		return &c, nil
+	}
+	var err error
+	if q.Before, err = cursor("before"); err != nil {
+		return db.Page{}, err
+	}
+	if q.After, err = cursor("after"); err != nil {
+		return db.Page{}, err
 	}
 
-	count, err := h.DB.ItemCount()
-	if err != nil {
-		return err
+	pageStr := r.FormValue("page")
+	if feedID != 0 || q.Before != nil || q.After != nil || pageStr == "" || pageStr == "0" {
+		return h.DB.Page(q)
 	}
-	if count == 0 && page == 0 {
-		contentTypeHTML(w)
-		return h.tmplts.ExecuteTemplate(w, "overview.html", data{Prev: -1, Next: -1})
+	page, err := strconv.ParseUint(pageStr, 10, 0)
+	const uintMax = ^uint(0)
+	if err != nil || uint(page) > uintMax/q.Limit {
+		return db.Page{}, badRequestf("invalid page %s", strconv.Quote(pageStr))
 	}
+	return h.offsetPage(uint(page)*q.Limit, q.Limit)
+}
 
-	offset := page * itemsPerPage
-	items, err := h.DB.Newest(offset, itemsPerPage)
+func (h *Handler) offsetPage(offset, limit uint) (db.Page, error) {
+	items, err := h.DB.Newest(offset, limit)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return badRequestf("overview: invalid page %d", page)
+			return db.Page{}, badRequestf("invalid offset %d", offset)
 		}
-		return err
+		return db.Page{}, err
+	}
+	count, err := h.DB.ItemCount()
+	if err != nil {
+		return db.Page{}, err
 	}
 
-	next := int(page) + 1
-	if offset+itemsPerPage >= uint(count) {
-		next = -1
+	p := db.Page{Items: items}
+	if offset > 0 {
+		c := db.CursorOf(items[0].Item)
+		p.Newer = &c
+	}
+	if offset+uint(len(items)) < uint(count) {
+		c := db.CursorOf(items[len(items)-1].Item)
+		p.Older = &c
 	}
+	return p, nil
+}
 
-	contentTypeHTML(w)
-	return h.tmplts.ExecuteTemplate(w, "overview.html", data{
-		Prev:  int(page) - 1,
-		Next:  next,
-		Items: items,
-	})
+func (h *Handler) overview(w http.ResponseWriter, r *http.Request) error {
+	p, err := h.itemsPage(r, 0)
+	if err != nil {
+		return err
+	}
+	// Limit keeps the page size of the request in the links to other pages.
+	return h.render(w, "overview.html", struct {
+		db.Page
+		Limit string
+	}{p, r.FormValue("limit")})
 }
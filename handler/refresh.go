@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/erikfastermann/feeder/updater"
+)
+
+// refreshFeeds refreshes the feed given by the id form value,
+// or all feeds if it is empty.
+func (h *Handler) refreshFeeds(ctx context.Context, r *http.Request) ([]updater.Result, error) {
+	ids := make([]int, 0)
+	if idStr := r.FormValue("id"); idStr != "" {
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			return nil, badRequestf("%s is an invalid id, %v", strconv.Quote(idStr), err)
+		}
+		ids = append(ids, id)
+	}
+
+	results, err := h.Updater.Refresh(ctx, ids...)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) > 0 && len(results) == 0 {
+		return nil, badRequestf("id %d not found in db", ids[0])
+	}
+	return results, nil
+}
+
+func (h *Handler) refresh(w http.ResponseWriter, r *http.Request) error {
+	results, err := h.refreshFeeds(r.Context(), r)
+	if err != nil {
+		return err
+	}
+	return h.render(w, "refresh.html", results)
+}
+
+func (h *Handler) apiRefresh(w http.ResponseWriter, r *http.Request) error {
+	results, err := h.refreshFeeds(r.Context(), r)
+	if err != nil {
+		return err
+	}
+
+	type result struct {
+		FeedID   int    `json:"feed_id"`
+		FeedURL  string `json:"feed_url"`
+		NewItems int    `json:"new_items"`
+		Error    string `json:"error,omitempty"`
+	}
+	out := make([]result, 0)
+	for _, res := range results {
+		r := result{
+			FeedID:   res.Feed.ID,
+			FeedURL:  res.Feed.FeedURL,
+			NewItems: res.NewItems,
+		}
+		if res.Err != nil {
+			r.Error = res.Err.Error()
+		}
+		out = append(out, r)
+	}
+	return writeJSON(w, out)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(v)
+}
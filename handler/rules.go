@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/erikfastermann/feeder/db"
+	"github.com/erikfastermann/feeder/rules"
+)
+
+// previewTimeout limits how long a preview waits for the feeds.
+const previewTimeout = 30 * time.Second
+
+// rulesPage lists the rules and adds or removes them on POST. With the
+// preview parameter it fetches the feeds of the rule in the form and
+// shows which of their current items it would match.
+func (h *Handler) rulesPage(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		if idStr := r.FormValue("remove"); idStr != "" {
+			id, err := strconv.Atoi(idStr)
+			if err != nil {
+				return badRequestf("%s is an invalid id, %v", strconv.Quote(idStr), err)
+			}
+			if err := h.DB.RemoveRule(id); err != nil {
+				if err == sql.ErrNoRows {
+					return badRequestf("rule id %d not found in db, %v", id, err)
+				}
+				return err
+			}
+		} else {
+			rule, err := ruleFromForm(r)
+			if err != nil {
+				return err
+			}
+			if _, err := h.DB.AddRule(rule.Rule); err != nil {
+				if err == sql.ErrNoRows {
+					return badRequestf("rules: feed id %d not found in db, %v", rule.FeedID, err)
+				}
+				return err
+			}
+		}
+		http.Redirect(w, r, routeRules, http.StatusSeeOther)
+		return nil
+	}
+
+	stored, err := h.DB.Rules()
+	if err != nil {
+		return err
+	}
+	feeds, err := h.DB.AllFeeds()
+	if err != nil {
+		return err
+	}
+	names := make(map[int]string)
+	for _, f := range feeds {
+		names[f.ID] = f.DisplayName()
+	}
+
+	type data struct {
+		Rules                    []db.Rule
+		Feeds                    []db.Feed
+		FeedNames                map[int]string
+		Fields, Matches, Actions []string
+		Form                     db.Rule
+		Preview                  *preview
+	}
+	d := data{
+		Rules:     stored,
+		Feeds:     feeds,
+		FeedNames: names,
+		Fields:    rules.Fields,
+		Matches:   rules.Matches,
+		Actions:   rules.Actions,
+		Form:      db.Rule{Field: rules.FieldTitle, Match: rules.MatchSubstring, Action: rules.ActionDrop},
+	}
+	if r.FormValue("preview") != "" {
+		rule, err := ruleFromForm(r)
+		if err != nil {
+			return err
+		}
+		d.Form = rule.Rule
+		if d.Preview, err = h.preview(r.Context(), rule, feeds); err != nil {
+			return err
+		}
+	}
+	return h.render(w, "rules.html", d)
+}
+
+// ruleFromForm compiles the rule in the form to validate it.
+func ruleFromForm(r *http.Request) (*rules.Rule, error) {
+	rule := db.Rule{
+		Field:   r.FormValue("field"),
+		Match:   r.FormValue("match"),
+		Pattern: r.FormValue("pattern"),
+		Action:  r.FormValue("action"),
+	}
+	if rule.Action == rules.ActionTag {
+		rule.Tag = strings.TrimSpace(r.FormValue("tag"))
+	}
+	if idStr := r.FormValue("feed"); idStr != "" {
+		var err error
+		rule.FeedID, err = strconv.Atoi(idStr)
+		if err != nil {
+			return nil, badRequestf("%s is an invalid feed id, %v", strconv.Quote(idStr), err)
+		}
+	}
+	if s := strings.TrimSpace(r.FormValue("older")); s != "" {
+		var err error
+		rule.OlderThan, err = parseAge(s)
+		if err != nil {
+			return nil, badRequestf("rules: invalid age %s, %v", strconv.Quote(s), err)
+		}
+	}
+
+	compiled, err := rules.Compile(rule)
+	if err != nil {
+		return nil, badRequestf("%v", err)
+	}
+	return compiled, nil
+}
+
+// parseAge parses a duration that may be given in days, like 7d.
+func parseAge(s string) (time.Duration, error) {
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.ParseUint(days, 10, 16)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+type preview struct {
+	Matches []previewMatch
+	// Checked is the number of items the rule was matched against.
+	Checked int
+	Errors  []string
+}
+
+type previewMatch struct {
+	Feed db.Feed
+	Item db.Item
+}
+
+// preview fetches the feed of the rule, or all enabled feeds for global
+// rules, and matches the rule against their items.
+func (h *Handler) preview(ctx context.Context, rule *rules.Rule, feeds []db.Feed) (*preview, error) {
+	todo := make([]db.Feed, 0)
+	for _, f := range feeds {
+		if (rule.FeedID == 0 && !f.Disabled) || f.ID == rule.FeedID {
+			todo = append(todo, f)
+		}
+	}
+	if rule.FeedID != 0 && len(todo) == 0 {
+		return nil, badRequestf("rules: feed id %d not found in db", rule.FeedID)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, previewTimeout)
+	defer cancel()
+	p := &preview{}
+	now := time.Now()
+	for _, f := range todo {
+		parsed, err := h.Updater.Fetch(ctx, f)
+		if err != nil {
+			p.Errors = append(p.Errors, f.DisplayName()+": "+err.Error())
+			continue
+		}
+		for i, item := range parsed.Items {
+			p.Checked++
+			if rule.Match(rules.Entry{Item: item, Entry: parsed.Entries[i]}, now) {
+				p.Matches = append(p.Matches, previewMatch{Feed: f, Item: item})
+			}
+		}
+	}
+	return p, nil
+}
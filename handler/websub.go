@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/erikfastermann/feeder/parser"
+	"github.com/erikfastermann/feeder/websub"
+	"github.com/erikfastermann/httpwrap"
+)
+
+// websub is the callback of the WebSub subscription of a feed, the id is
+// part of the path. Hubs verify requests with GET and push content with
+// POST. It is served without authentication.
+func (h *Handler) websub(w http.ResponseWriter, r *http.Request) error {
+	split := strings.Split(path.Clean(r.URL.Path), "/")
+	if len(split) != 3 {
+		return badRequestf("websub: missing id in %s", r.URL.Path)
+	}
+	id, err := strconv.Atoi(split[2])
+	if err != nil {
+		return badRequestf("%s is an invalid id, %v", strconv.Quote(split[2]), err)
+	}
+	if h.WebSub == nil {
+		return httpwrap.Error{
+			StatusCode: http.StatusNotFound,
+			Err:        fmt.Errorf("websub: not enabled"),
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		challenge, err := h.WebSub.Verify(id, r.URL.Query())
+		if err != nil {
+			return httpwrap.Error{StatusCode: http.StatusNotFound, Err: err}
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, challenge)
+		return nil
+	case http.MethodPost:
+		gone := httpwrap.Error{
+			StatusCode: http.StatusGone,
+			Err:        fmt.Errorf("websub: feed %d is not subscribed", id),
+		}
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, parser.MaxBodySize+1))
+		if err != nil {
+			return badRequestf("websub: %v", err)
+		}
+		if len(body) > parser.MaxBodySize {
+			return httpwrap.Error{StatusCode: http.StatusRequestEntityTooLarge, Err: parser.ErrTooLarge}
+		}
+		if err := h.WebSub.Receive(id, body, r.Header.Get(websub.HeaderSignature)); err != nil {
+			if err == websub.ErrUnknown {
+				return gone
+			}
+			// The hub must not learn whether the signature was valid.
+			h.Logger.Printf("feed %d: ignoring pushed content, %v", id, err)
+			w.WriteHeader(http.StatusAccepted)
+			return nil
+		}
+		res, err := h.Updater.Push(id, body, r.Header.Get("Content-Type"))
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return gone
+			}
+			return err
+		}
+		if res.Err != nil {
+			return badRequestf("websub: %v", res.Err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	default:
+		return httpwrap.Error{
+			StatusCode: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("websub: method %s not allowed", r.Method),
+		}
+	}
+}
@@ -0,0 +1,273 @@
+// Package icon finds and caches the favicons of feeds, so the UI can show
+// them without the browser contacting the sites of the feeds.
+package icon
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/erikfastermann/feeder/db"
+	"golang.org/x/net/html"
+)
+
+const (
+	// DirName is the directory in the data directory that holds the icons.
+	DirName = "icons"
+
+	DefaultMaxSize = 64 << 10
+	DefaultMaxAge  = 30 * 24 * time.Hour
+
+	// maxPageSize limits how much of a website is searched for icon links.
+	maxPageSize = 512 << 10
+)
+
+// Cache stores one icon per feed in Dir, in a file named by the feed id.
+// An empty file records that no icon was found.
+type Cache struct {
+	Dir string
+	// MaxSize is the largest icon in bytes that is stored.
+	MaxSize int64
+	// MaxAge is the time after which icons, and feeds without one,
+	// are looked up again.
+	MaxAge time.Duration
+	Client *http.Client
+}
+
+// Open creates dir if necessary and returns a Cache with the defaults.
+func Open(dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Cache{
+		Dir:     dir,
+		MaxSize: DefaultMaxSize,
+		MaxAge:  DefaultMaxAge,
+		Client:  &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (c *Cache) path(id int) string {
+	return filepath.Join(c.Dir, strconv.Itoa(id))
+}
+
+// Stale reports whether the icon of the feed should be looked up.
+func (c *Cache) Stale(id int) bool {
+	fi, err := os.Stat(c.path(id))
+	return err != nil || time.Since(fi.ModTime()) > c.MaxAge
+}
+
+// Has reports whether an icon is stored for the feed.
+func (c *Cache) Has(id int) bool {
+	fi, err := os.Stat(c.path(id))
+	return err == nil && fi.Size() > 0
+}
+
+// Get returns the icon of the feed, its content type and when it was
+// fetched. If there is none, the error is os.ErrNotExist.
+func (c *Cache) Get(id int) (data []byte, contentType string, modTime time.Time, err error) {
+	f, err := os.Open(c.path(id))
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+	if fi.Size() == 0 {
+		return nil, "", time.Time{}, os.ErrNotExist
+	}
+	data, err = ioutil.ReadAll(f)
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+	return data, http.DetectContentType(data), fi.ModTime(), nil
+}
+
+// Prune removes the icons of feeds whose ids are not in keep.
+func (c *Cache) Prune(keep map[int]bool) error {
+	entries, err := ioutil.ReadDir(c.Dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		id, err := strconv.Atoi(e.Name())
+		if err != nil || keep[id] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.Dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Update looks up the icon of the feed and stores it. The candidates are
+// the icon named by the feed, the icons linked from its website and
+// /favicon.ico, in that order. If none of them is usable, an empty file
+// is stored. The returned error is only about storing the result.
+func (c *Cache) Update(ctx context.Context, feed db.Feed) error {
+	var data []byte
+	for _, u := range c.candidates(ctx, feed) {
+		var err error
+		if data, err = c.fetch(ctx, u); err == nil {
+			break
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.write(feed.ID, data)
+}
+
+func (c *Cache) write(id int, data []byte) error {
+	f, err := ioutil.TempFile(c.Dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), c.path(id))
+}
+
+func (c *Cache) candidates(ctx context.Context, feed db.Feed) []string {
+	urls := make([]string, 0)
+	seen := make(map[string]bool)
+	add := func(base *url.URL, ref string) {
+		ref = strings.TrimSpace(ref)
+		if base == nil || ref == "" {
+			return
+		}
+		u, err := base.Parse(ref)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || seen[u.String()] {
+			return
+		}
+		seen[u.String()] = true
+		urls = append(urls, u.String())
+	}
+
+	feedURL, _ := url.Parse(feed.FeedURL)
+	add(feedURL, feed.Icon)
+
+	site, err := url.Parse(feed.Site())
+	if err != nil {
+		return urls
+	}
+	page, links := c.pageIcons(ctx, site.String())
+	for _, l := range links {
+		add(page, l)
+	}
+	add(site, "/favicon.ico")
+	return urls
+}
+
+// pageIcons returns the final URL of the page and the targets of its icon
+// links, icons first and touch icons last. Errors are ignored, the page
+// is only one of the places an icon may be found.
+func (c *Cache) pageIcons(ctx context.Context, pageURL string) (*url.URL, []string) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return nil, nil
+	}
+	res, err := c.Client.Do(req)
+	if err != nil {
+		return nil, nil
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, nil
+	}
+
+	base := res.Request.URL
+	var icons, touch []string
+	z := html.NewTokenizer(io.LimitReader(res.Body, maxPageSize))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		name, hasAttr := z.TagName()
+		if string(name) == "body" {
+			break
+		}
+		if !hasAttr || (string(name) != "link" && string(name) != "base") {
+			continue
+		}
+
+		var rel, href string
+		for hasAttr {
+			var k, v []byte
+			k, v, hasAttr = z.TagAttr()
+			switch string(k) {
+			case "rel":
+				rel = strings.ToLower(string(v))
+			case "href":
+				href = string(v)
+			}
+		}
+		if string(name) == "base" {
+			if u, err := base.Parse(href); err == nil {
+				base = u
+			}
+			continue
+		}
+		for _, r := range strings.Fields(rel) {
+			if r == "icon" {
+				icons = append(icons, href)
+				break
+			}
+			if r == "apple-touch-icon" {
+				touch = append(touch, href)
+				break
+			}
+		}
+	}
+	return base, append(icons, touch...)
+}
+
+func (c *Cache) fetch(ctx context.Context, iconURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, iconURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("icon: %s returned status %d", iconURL, res.StatusCode)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(res.Body, c.MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > c.MaxSize {
+		return nil, fmt.Errorf("icon: %s is larger than %d bytes", iconURL, c.MaxSize)
+	}
+	// Only raster images are accepted, SVGs may contain scripts.
+	if ct := http.DetectContentType(data); !strings.HasPrefix(ct, "image/") {
+		return nil, fmt.Errorf("icon: %s is not an image (%s)", iconURL, ct)
+	}
+	return data, nil
+}
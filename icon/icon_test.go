@@ -0,0 +1,91 @@
+package icon
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/erikfastermann/feeder/db"
+)
+
+// png is the start of a PNG file, enough for content sniffing.
+var png = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestUpdate(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`<html><head>
+<link rel="apple-touch-icon" href="/touch.png">
+<link rel="shortcut icon" href="/static/big.png">
+<link rel="icon" href="/static/page.html">
+</head><body><link rel="icon" href="/body.png"></body></html>`))
+	})
+	mux.HandleFunc("/static/big.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(append(png, make([]byte, 100)...))
+	})
+	mux.HandleFunc("/static/page.html", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html></html>"))
+	})
+	mux.HandleFunc("/touch.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(png)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir(os.TempDir(), "feeder-icon-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.MaxSize = 64
+
+	ctx := context.Background()
+	feed := db.Feed{ID: 1, Host: srv.URL, FeedURL: srv.URL + "/feed"}
+	if !c.Stale(feed.ID) {
+		t.Fatal("icon not stale before the first update")
+	}
+	if err := c.Update(ctx, feed); err != nil {
+		t.Fatal(err)
+	}
+	if c.Stale(feed.ID) || !c.Has(feed.ID) {
+		t.Fatal("icon missing after update")
+	}
+	data, contentType, _, err := c.Get(feed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, png) || contentType != "image/png" {
+		t.Fatalf("got %q (%s), want the touch icon", data, contentType)
+	}
+
+	missing := db.Feed{ID: 2, Host: srv.URL + "/missing", FeedURL: srv.URL + "/feed"}
+	missing.Icon = "/static/page.html"
+	if err := c.Update(ctx, missing); err != nil {
+		t.Fatal(err)
+	}
+	if c.Stale(missing.ID) || c.Has(missing.ID) {
+		t.Fatal("failed lookup not recorded")
+	}
+	if _, _, _, err := c.Get(missing.ID); !os.IsNotExist(err) {
+		t.Fatalf("got %v, want not exist", err)
+	}
+
+	if err := c.Prune(map[int]bool{missing.ID: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Stale(feed.ID) {
+		t.Fatal("icon of removed feed not pruned")
+	}
+}
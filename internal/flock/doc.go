@@ -0,0 +1,2 @@
+// Package flock locks files between processes sharing a data directory.
+package flock
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package flock
+
+import "os"
+
+// Without file locks, processes must not share the files.
+
+// Lock does nothing.
+func Lock(f *os.File) error {
+	return nil
+}
+
+// Unlock does nothing.
+func Unlock(f *os.File) error {
+	return nil
+}
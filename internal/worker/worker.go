@@ -0,0 +1,73 @@
+// Package worker runs the goroutines of a background component
+// and stops them when it is shut down.
+package worker
+
+import (
+	"context"
+	"sync"
+)
+
+// Group runs goroutines until Shutdown is called.
+// The zero value is ready to use.
+type Group struct {
+	initOnce sync.Once
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+func (g *Group) init() {
+	g.initOnce.Do(func() {
+		g.ctx, g.cancel = context.WithCancel(context.Background())
+		g.stop = make(chan struct{})
+	})
+}
+
+// Go runs f in a new goroutine. It must not be called after Shutdown.
+func (g *Group) Go(f func()) {
+	g.init()
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		f()
+	}()
+}
+
+// Context is cancelled when Shutdown gives up waiting.
+func (g *Group) Context() context.Context {
+	g.init()
+	return g.ctx
+}
+
+// Stopping is closed as soon as Shutdown is called.
+func (g *Group) Stopping() <-chan struct{} {
+	g.init()
+	return g.stop
+}
+
+// Shutdown closes Stopping and waits for the goroutines to return.
+// If ctx expires first, Context is cancelled and ctx.Err() is returned
+// once they returned.
+func (g *Group) Shutdown(ctx context.Context) error {
+	g.init()
+	g.stopOnce.Do(func() {
+		close(g.stop)
+	})
+	defer g.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		g.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
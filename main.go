@@ -1,16 +1,40 @@
 package main
 
 import (
+	"context"
+	"crypto/x509"
+	"embed"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/erikfastermann/feeder/db"
+	"github.com/erikfastermann/feeder/digest"
 	"github.com/erikfastermann/feeder/handler"
+	"github.com/erikfastermann/feeder/icon"
+	"github.com/erikfastermann/feeder/notify"
+	"github.com/erikfastermann/feeder/parser"
+	"github.com/erikfastermann/feeder/secret"
+	"github.com/erikfastermann/feeder/updater"
+	"github.com/erikfastermann/feeder/websub"
 	"github.com/erikfastermann/httpwrap"
 )
 
+//go:embed template static
+var assets embed.FS
+
+const shutdownTimeout = 15 * time.Second
+
 func main() {
 	if err := run(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -19,13 +43,384 @@ func main() {
 }
 
 func run() error {
-	if len(os.Args) != 8 {
-		return fmt.Errorf("USAGE: %s ADDRESS CERT_FILE KEY_FILE TEMPLATE_GLOB CSV_CTR CSV_FEEDS CSV_ITEMS", os.Args[0])
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "worker":
+			return runWorker(os.Args[2:])
+		case "serve":
+			return runServer(os.Args[2:], false)
+		}
+	}
+	return runServer(os.Args[1:], true)
+}
+
+func usage() error {
+	return fmt.Errorf("USAGE: %s ADDRESS CERT_FILE KEY_FILE DATA_DIR\n"+
+		"       %s serve ADDRESS CERT_FILE KEY_FILE DATA_DIR\n"+
+		"       %s worker DATA_DIR", os.Args[0], os.Args[0], os.Args[0])
+}
+
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	s := os.Getenv(name)
+	if s == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("environment variable %s: invalid duration %q", name, s)
+	}
+	return d, nil
+}
+
+func envInt(name string, def int) (int, error) {
+	s := os.Getenv(name)
+	if s == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("environment variable %s: invalid number %q", name, s)
+	}
+	return i, nil
+}
+
+func envBool(name string, def bool) (bool, error) {
+	s := os.Getenv(name)
+	if s == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("environment variable %s: invalid boolean %q", name, s)
+	}
+	return b, nil
+}
+
+func openDB(dataDir string) (*db.DB, error) {
+	flagEdited, err := envBool("FEEDER_FLAG_EDITED", false)
+	if err != nil {
+		return nil, err
+	}
+	csv, err := db.Open(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	csv.FlagEdited = flagEdited
+	return csv, nil
+}
+
+// httpConfig configures how feeds and icons are fetched. Private
+// networks are blocked unless they are listed in FEEDER_FETCH_ALLOW.
+func httpConfig() (parser.HTTPConfig, error) {
+	c := parser.HTTPConfig{Policy: &parser.Policy{}}
+	var err error
+	if c.Timeout, err = envDuration("FEEDER_FETCH_TIMEOUT", parser.DefaultTimeout); err != nil {
+		return c, err
+	}
+	if c.Policy.Allow, err = parser.ParseAllowList(os.Getenv("FEEDER_FETCH_ALLOW")); err != nil {
+		return c, fmt.Errorf("environment variable FEEDER_FETCH_ALLOW: %v", err)
+	}
+	if s := os.Getenv("FEEDER_PROXY"); s != "" {
+		if c.Proxy, err = url.Parse(s); err != nil {
+			return c, fmt.Errorf("environment variable FEEDER_PROXY: %v", err)
+		}
+	}
+	if path := os.Getenv("FEEDER_CA_FILE"); path != "" {
+		pem, err := ioutil.ReadFile(path)
+		if err != nil {
+			return c, err
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return c, fmt.Errorf("environment variable FEEDER_CA_FILE: no certificates in %s", path)
+		}
+	}
+	return c, nil
+}
+
+// newSecrets returns the Box for FEEDER_SECRET_KEY, or nil if it is unset.
+func newSecrets() (*secret.Box, error) {
+	key := os.Getenv("FEEDER_SECRET_KEY")
+	if key == "" {
+		return nil, nil
+	}
+	box, err := secret.New(key)
+	if err != nil {
+		return nil, fmt.Errorf("environment variable FEEDER_SECRET_KEY: %v", err)
+	}
+	return box, nil
+}
+
+func openIcons(dataDir string, client *http.Client) (*icon.Cache, error) {
+	icons, err := icon.Open(filepath.Join(dataDir, icon.DirName))
+	if err != nil {
+		return nil, err
+	}
+	icons.Client = client
+	maxSize, err := envInt("FEEDER_ICON_MAX_SIZE", icon.DefaultMaxSize)
+	if err != nil {
+		return nil, err
+	}
+	icons.MaxSize = int64(maxSize)
+	return icons, nil
+}
+
+// newNotifier returns the Notifier for the targets in FEEDER_NOTIFY_FILE,
+// or nil if it is unset.
+func newNotifier(dataDir string) (*notify.Notifier, error) {
+	path := os.Getenv("FEEDER_NOTIFY_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	targets, err := notify.LoadTargets(path)
+	if err != nil {
+		return nil, fmt.Errorf("environment variable FEEDER_NOTIFY_FILE: %v", err)
+	}
+	dryRun, err := envBool("FEEDER_NOTIFY_DRY_RUN", false)
+	if err != nil {
+		return nil, err
+	}
+	n, err := notify.Open(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	n.Logger = log.New(os.Stderr, "NOTIFY ", log.LstdFlags)
+	n.Targets = targets
+	n.DryRun = dryRun
+	return n, nil
+}
+
+// newMailer returns the Mailer for the digests in FEEDER_DIGEST_FILE,
+// or nil if it is unset. The digests link to FEEDER_PUBLIC_URL, if set.
+func newMailer(dataDir string, csv *db.DB) (*digest.Mailer, error) {
+	path := os.Getenv("FEEDER_DIGEST_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	digests, err := digest.LoadDigests(path)
+	if err != nil {
+		return nil, fmt.Errorf("environment variable FEEDER_DIGEST_FILE: %v", err)
+	}
+	m, err := digest.Open(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	m.Logger = log.New(os.Stderr, "DIGEST ", log.LstdFlags)
+	m.DB = csv
+	m.Digests = digests
+	m.URL = os.Getenv("FEEDER_PUBLIC_URL")
+	return m, nil
+}
+
+// newSubscriber returns the Subscriber for the WebSub hubs of the feeds,
+// or nil if FEEDER_PUBLIC_URL, the URL the hubs reach the server under,
+// is unset.
+func newSubscriber(dataDir string, client *http.Client) (*websub.Subscriber, error) {
+	s := os.Getenv("FEEDER_PUBLIC_URL")
+	if s == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("environment variable FEEDER_PUBLIC_URL: invalid URL %q", s)
+	}
+	sub, err := websub.Open(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	sub.Logger = log.New(os.Stderr, "WEBSUB ", log.LstdFlags)
+	// The handler serves the callbacks under /websub/ID.
+	sub.Callback = strings.TrimSuffix(u.String(), "/") + "/websub/"
+	sub.Client = client
+	return sub, nil
+}
+
+func newUpdater(csv *db.DB, p *parser.Parser, secrets *secret.Box, icons *icon.Cache) (*updater.Updater, error) {
+	interval, err := envDuration("FEEDER_UPDATE_INTERVAL", updater.DefaultInterval)
+	if err != nil {
+		return nil, err
+	}
+	if interval == 0 {
+		return nil, fmt.Errorf("environment variable FEEDER_UPDATE_INTERVAL: must not be 0")
+	}
+
+	var r db.Retention
+	if r.MaxAge, err = envDuration("FEEDER_RETAIN_MAX_AGE", 0); err != nil {
+		return nil, err
 	}
-	addr := os.Args[1]
-	crt, key := os.Args[2], os.Args[3]
-	tmplt := os.Args[4]
-	ctr, feeds, items := os.Args[5], os.Args[6], os.Args[7]
+	if r.MaxPerFeed, err = envInt("FEEDER_RETAIN_MAX_PER_FEED", 0); err != nil {
+		return nil, err
+	}
+	if r.KeepUnread, err = envBool("FEEDER_RETAIN_KEEP_UNREAD", false); err != nil {
+		return nil, err
+	}
+	if r.KeepStarred, err = envBool("FEEDER_RETAIN_KEEP_STARRED", true); err != nil {
+		return nil, err
+	}
+	if r.SeenAge, err = envDuration("FEEDER_RETAIN_SEEN_AGE", 90*24*time.Hour); err != nil {
+		return nil, err
+	}
+
+	fetchIcons, err := envBool("FEEDER_FETCH_ICONS", true)
+	if err != nil {
+		return nil, err
+	}
+	u := &updater.Updater{
+		Logger:    log.New(os.Stderr, "ERROR ", log.LstdFlags),
+		DB:        csv,
+		Parser:    p,
+		Secrets:   secrets,
+		Interval:  interval,
+		Retention: r,
+	}
+	if fetchIcons {
+		u.Icons = icons
+	}
+	return u, nil
+}
+
+// reloadShared loads the changes of other processes sharing the data
+// directory every FEEDER_RELOAD_INTERVAL until ctx is done. sub may be nil.
+// The returned channel is closed once it stopped.
+func reloadShared(ctx context.Context, csv *db.DB, sub *websub.Subscriber) (<-chan struct{}, error) {
+	interval, err := envDuration("FEEDER_RELOAD_INTERVAL", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	done := make(chan struct{})
+	if interval == 0 {
+		close(done)
+		return done, nil
+	}
+	logger := log.New(os.Stderr, "ERROR ", log.LstdFlags)
+	go func() {
+		defer close(done)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				if err := csv.Reload(); err != nil {
+					logger.Print(err)
+				}
+				if sub == nil {
+					continue
+				}
+				if err := sub.Reload(); err != nil {
+					logger.Print(err)
+				}
+			}
+		}
+	}()
+	return done, nil
+}
+
+// runWorker only fetches feeds, without serving the web UI.
+// It can share the data directory with a server started in serve mode.
+// It subscribes the feeds to their WebSub hubs, the server sends the
+// requests and receives the pushed content. Without a server, the
+// feeds are polled as usual.
+func runWorker(args []string) error {
+	if len(args) != 1 {
+		return usage()
+	}
+	dataDir := args[0]
+
+	config, err := httpConfig()
+	if err != nil {
+		return err
+	}
+	client := parser.NewClient(config)
+	p := &parser.Parser{Fetcher: client, Config: config}
+	secrets, err := newSecrets()
+	if err != nil {
+		return err
+	}
+	icons, err := openIcons(dataDir, client)
+	if err != nil {
+		return err
+	}
+	csv, err := openDB(dataDir)
+	if err != nil {
+		return err
+	}
+	u, err := newUpdater(csv, p, secrets, icons)
+	if err != nil {
+		csv.Close()
+		return err
+	}
+	n, err := newNotifier(dataDir)
+	if err != nil {
+		csv.Close()
+		return err
+	}
+	u.Notifier = n
+	sub, err := newSubscriber(dataDir, client)
+	if err != nil {
+		csv.Close()
+		return err
+	}
+	u.WebSub = sub
+	mailer, err := newMailer(dataDir, csv)
+	if err != nil {
+		csv.Close()
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	reloaded, err := reloadShared(ctx, csv, sub)
+	if err != nil {
+		csv.Close()
+		return err
+	}
+	if n != nil {
+		n.Start()
+	}
+	if mailer != nil {
+		mailer.Start()
+	}
+	u.Start()
+	<-ctx.Done()
+	stop()
+	<-reloaded
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err = u.Shutdown(shutdownCtx)
+	if mailer != nil {
+		if shutdownErr := mailer.Shutdown(shutdownCtx); shutdownErr != nil && err == nil {
+			err = shutdownErr
+		}
+	}
+	if n != nil {
+		if shutdownErr := n.Shutdown(shutdownCtx); shutdownErr != nil && err == nil {
+			err = shutdownErr
+		}
+	}
+	if sub != nil {
+		if shutdownErr := sub.Shutdown(shutdownCtx); shutdownErr != nil && err == nil {
+			err = shutdownErr
+		}
+	}
+	if closeErr := csv.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// runServer serves the web UI. If fetch is false, feeds are only fetched
+// when they are refreshed in the UI, the scheduled updates, notifications
+// and digests are left to a worker sharing the data directory.
+func runServer(args []string, fetch bool) error {
+	if len(args) != 4 {
+		return usage()
+	}
+	addr := args[0]
+	crt, key := args[1], args[2]
+	dataDir := args[3]
 
 	username := os.Getenv("FEEDER_USERNAME")
 	if username == "" {
@@ -36,18 +431,150 @@ func run() error {
 		return fmt.Errorf("environment variable FEEDER_PASSWORD empty or unset")
 	}
 
-	csv, err := db.Open(ctr, feeds, items)
+	// Optional: a directory with template/ and static/ files that replace
+	// the embedded defaults, and dev mode to re-parse templates on every request.
+	overrideDir := os.Getenv("FEEDER_OVERRIDE_DIR")
+	dev := os.Getenv("FEEDER_DEV") != ""
+	pageSize, err := envInt("FEEDER_PAGE_SIZE", handler.DefaultPageSize)
 	if err != nil {
 		return err
 	}
-	defer csv.Close()
+
+	config, err := httpConfig()
+	if err != nil {
+		return err
+	}
+	client := parser.NewClient(config)
+	p := &parser.Parser{Fetcher: client, Config: config}
+	secrets, err := newSecrets()
+	if err != nil {
+		return err
+	}
+	icons, err := openIcons(dataDir, client)
+	if err != nil {
+		return err
+	}
+	csv, err := openDB(dataDir)
+	if err != nil {
+		return err
+	}
+	u, err := newUpdater(csv, p, secrets, icons)
+	if err != nil {
+		csv.Close()
+		return err
+	}
+	sub, err := newSubscriber(dataDir, client)
+	if err != nil {
+		csv.Close()
+		return err
+	}
+	u.WebSub = sub
+	// Notifications and digests are sent by the process that runs the
+	// scheduled updates, which owns their files in the data directory.
+	var n *notify.Notifier
+	var mailer *digest.Mailer
+	if fetch {
+		n, err = newNotifier(dataDir)
+		if err != nil {
+			csv.Close()
+			return err
+		}
+		u.Notifier = n
+		mailer, err = newMailer(dataDir, csv)
+		if err != nil {
+			csv.Close()
+			return err
+		}
+	}
 
 	h := &handler.Handler{
-		Logger:       log.New(os.Stderr, "ERROR ", log.LstdFlags),
-		Username:     username,
-		Password:     password,
-		TemplateGlob: tmplt,
-		DB:           csv,
+		Logger:      log.New(os.Stderr, "ERROR ", log.LstdFlags),
+		Username:    username,
+		Password:    password,
+		Assets:      assets,
+		OverrideDir: overrideDir,
+		Dev:         dev,
+		PageSize:    pageSize,
+		DB:          csv,
+		Parser:      p,
+		Secrets:     secrets,
+		Updater:     u,
+		Icons:       icons,
+		Notifier:    n,
+		WebSub:      sub,
+	}
+	if err := h.Init(); err != nil {
+		csv.Close()
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	reloaded, err := reloadShared(ctx, csv, sub)
+	if err != nil {
+		csv.Close()
+		return err
+	}
+
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: httpwrap.Log(httpwrap.HandleError(h)),
+	}
+	srv.RegisterOnShutdown(h.CloseStreams)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServeTLS(crt, key)
+	}()
+	if n != nil {
+		n.Start()
+	}
+	if sub != nil {
+		sub.Start()
+	}
+	if mailer != nil {
+		mailer.Start()
+	}
+	if fetch {
+		u.Start()
+	}
+
+	select {
+	case err = <-serveErr:
+	case <-ctx.Done():
+	}
+	stop()
+	<-reloaded
+
+	// Stop accepting requests, wait for running requests and fetches,
+	// then close the store once no more writes can happen.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if shutdownErr := srv.Shutdown(shutdownCtx); shutdownErr != nil && err == nil {
+		err = shutdownErr
+	}
+	if shutdownErr := u.Shutdown(shutdownCtx); shutdownErr != nil && err == nil {
+		err = shutdownErr
+	}
+	if sub != nil {
+		if shutdownErr := sub.Shutdown(shutdownCtx); shutdownErr != nil && err == nil {
+			err = shutdownErr
+		}
+	}
+	if mailer != nil {
+		if shutdownErr := mailer.Shutdown(shutdownCtx); shutdownErr != nil && err == nil {
+			err = shutdownErr
+		}
+	}
+	if n != nil {
+		if shutdownErr := n.Shutdown(shutdownCtx); shutdownErr != nil && err == nil {
+			err = shutdownErr
+		}
+	}
+	if closeErr := csv.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
 	}
-	return http.ListenAndServeTLS(addr, crt, key, httpwrap.Log(httpwrap.HandleError(h)))
+	return err
 }
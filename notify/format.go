@@ -0,0 +1,173 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers of the generic webhook. The signature is the hex encoded
+// HMAC-SHA256 of the timestamp, a dot and the body, keyed with the secret.
+const (
+	HeaderTimestamp = "X-Feeder-Timestamp"
+	HeaderSignature = "X-Feeder-Signature"
+)
+
+// discordMax is the maximum length of a Discord message.
+const discordMax = 2000
+
+// StatusError is returned if a webhook responds with an error.
+type StatusError struct {
+	Target     string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("notify: %s responded with %d %s", e.Target, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// retryable reports whether a failed delivery may succeed later.
+func retryable(err error) bool {
+	switch err := err.(type) {
+	case *StatusError:
+		return err.StatusCode >= 500 || err.StatusCode == http.StatusTooManyRequests ||
+			err.StatusCode == http.StatusRequestTimeout
+	case *textproto.Error:
+		return err.Code < 500
+	}
+	return true
+}
+
+// Sign returns the signature of a webhook body sent at timestamp.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	io.WriteString(mac, timestamp)
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (n *Notifier) send(ctx context.Context, t Target, items []Item) error {
+	if t.Type == TypeEmail {
+		subject, body := digest(items)
+		if n.DryRun {
+			n.Logger.Printf("dry run: email to %s, %s\n%s", strings.Join(t.To, ", "), subject, body)
+			return nil
+		}
+		return t.SMTP.Send(ctx, t.To, subject, body)
+	}
+
+	var payload interface{}
+	switch t.Type {
+	case TypeWebhook:
+		payload = struct {
+			Items []Item `json:"items"`
+		}{items}
+	case TypeSlack:
+		payload = struct {
+			Text string `json:"text"`
+		}{slackText(items)}
+	case TypeDiscord:
+		payload = struct {
+			Content string `json:"content"`
+		}{discordContent(items)}
+	case TypeMatrix:
+		text, html := matrixText(items)
+		payload = struct {
+			Text string `json:"text"`
+			HTML string `json:"html"`
+		}{text, html}
+	default:
+		return fmt.Errorf("notify: target %s: unknown type %q", t.Name, t.Type)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if n.DryRun {
+		n.Logger.Printf("dry run: POST %s\n%s", t.Name, body)
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if t.Secret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(HeaderTimestamp, ts)
+		req.Header.Set(HeaderSignature, Sign(t.Secret, ts, body))
+	}
+	res, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return &StatusError{Target: t.Name, StatusCode: res.StatusCode}
+	}
+	return nil
+}
+
+func slackText(items []Item) string {
+	escape := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	var b strings.Builder
+	for _, i := range items {
+		fmt.Fprintf(&b, "<%s|%s> (%s)\n", escape.Replace(i.URL), escape.Replace(i.Title), escape.Replace(i.FeedName))
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func discordContent(items []Item) string {
+	escape := strings.NewReplacer("[", "\\[", "]", "\\]", "*", "\\*", "_", "\\_", "`", "\\`")
+	var b strings.Builder
+	for n, i := range items {
+		line := fmt.Sprintf("[%s](<%s>) (%s)\n", escape.Replace(i.Title), i.URL, escape.Replace(i.FeedName))
+		more := fmt.Sprintf("and %d more", len(items)-n)
+		if b.Len()+len(line)+len(more) > discordMax {
+			b.WriteString(more)
+			break
+		}
+		b.WriteString(line)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func matrixText(items []Item) (string, string) {
+	var text, h strings.Builder
+	h.WriteString("<ul>")
+	for _, i := range items {
+		fmt.Fprintf(&text, "%s (%s) %s\n", i.Title, i.FeedName, i.URL)
+		fmt.Fprintf(&h, `<li><a href="%s">%s</a> (%s)</li>`,
+			html.EscapeString(i.URL), html.EscapeString(i.Title), html.EscapeString(i.FeedName))
+	}
+	h.WriteString("</ul>")
+	return strings.TrimSuffix(text.String(), "\n"), h.String()
+}
+
+// digest returns the subject and text of an email listing the items.
+func digest(items []Item) (string, string) {
+	subject := "1 new item"
+	if len(items) != 1 {
+		subject = strconv.Itoa(len(items)) + " new items"
+	}
+	var b strings.Builder
+	for _, i := range items {
+		fmt.Fprintf(&b, "%s\n%s\n%s, %s\n\n", i.Title, i.URL, i.FeedName, i.Added.Format(time.RFC1123))
+	}
+	return subject, b.String()
+}
@@ -0,0 +1,439 @@
+// Package notify delivers new items to webhooks and by email.
+package notify
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/erikfastermann/feeder/db"
+	"github.com/erikfastermann/feeder/internal/worker"
+)
+
+// Types of targets.
+const (
+	// TypeWebhook posts the items as JSON, signed with the Secret.
+	TypeWebhook = "webhook"
+	TypeSlack   = "slack"
+	TypeDiscord = "discord"
+	// TypeMatrix posts to a Matrix hookshot generic webhook.
+	TypeMatrix = "matrix"
+	TypeEmail  = "email"
+)
+
+const (
+	// LogFile in the directory passed to Open keeps the recent deliveries.
+	LogFile = "notify.csv"
+
+	DefaultAttempts = 3
+	DefaultBackoff  = 10 * time.Second
+	DefaultTimeout  = 10 * time.Second
+
+	maxLog    = 1000
+	queueSize = 64
+)
+
+// Target is where new items are delivered. Items are delivered if they
+// are from one of the Feeds or have one of the Tags set by rules.
+// Without Feeds and Tags all new items are delivered.
+type Target struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+
+	// URL and Secret are used by the webhook types.
+	URL    string `json:"url,omitempty"`
+	Secret string `json:"secret,omitempty"`
+
+	// SMTP and To are used by the email type.
+	SMTP SMTP     `json:"smtp"`
+	To   []string `json:"to,omitempty"`
+
+	Feeds []int    `json:"feeds,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+func (t Target) validate() error {
+	if t.Name == "" {
+		return errors.New("notify: target without a name")
+	}
+	switch t.Type {
+	case TypeWebhook, TypeSlack, TypeDiscord, TypeMatrix:
+		u, err := url.Parse(t.URL)
+		if err != nil {
+			return fmt.Errorf("notify: target %s: %v", t.Name, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("notify: target %s: invalid URL %s", t.Name, t.URL)
+		}
+		if t.Type == TypeWebhook && t.Secret == "" {
+			return fmt.Errorf("notify: target %s: webhooks need a secret", t.Name)
+		}
+	case TypeEmail:
+		if t.SMTP.Addr == "" || t.SMTP.From == "" || len(t.To) == 0 {
+			return fmt.Errorf("notify: target %s: email needs smtp.addr, smtp.from and to", t.Name)
+		}
+	default:
+		return fmt.Errorf("notify: target %s: unknown type %q", t.Name, t.Type)
+	}
+	return nil
+}
+
+func (t Target) wants(item db.Item) bool {
+	if len(t.Feeds) == 0 && len(t.Tags) == 0 {
+		return true
+	}
+	for _, id := range t.Feeds {
+		if id == item.FeedID {
+			return true
+		}
+	}
+	for _, tag := range t.Tags {
+		for _, itemTag := range item.Tags {
+			if tag == itemTag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// LoadTargets reads a JSON array of targets from path.
+func LoadTargets(path string) ([]Target, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var targets []Target
+	if err := json.Unmarshal(data, &targets); err != nil {
+		return nil, fmt.Errorf("notify: %s: %v", path, err)
+	}
+	names := make(map[string]bool)
+	for _, t := range targets {
+		if err := t.validate(); err != nil {
+			return nil, err
+		}
+		if names[t.Name] {
+			return nil, fmt.Errorf("notify: duplicate target %s", t.Name)
+		}
+		names[t.Name] = true
+	}
+	return targets, nil
+}
+
+// Event holds the new items of a feed.
+type Event struct {
+	Feed  db.Feed
+	Items []db.Item
+}
+
+// Item is a new item as it is delivered.
+type Item struct {
+	ID       int       `json:"id"`
+	FeedID   int       `json:"feed_id"`
+	FeedName string    `json:"feed_name"`
+	Title    string    `json:"title"`
+	URL      string    `json:"url"`
+	Added    time.Time `json:"added"`
+	Tags     []string  `json:"tags,omitempty"`
+}
+
+// Delivery is an attempt to deliver items to a target.
+type Delivery struct {
+	Time    time.Time
+	Target  string
+	Items   int
+	Attempt int
+	// Err is empty if the attempt succeeded.
+	Err    string
+	DryRun bool
+}
+
+const (
+	dTime    = 0
+	dTarget  = 1
+	dItems   = 2
+	dAttempt = 3
+	dErr     = 4
+	dDryRun  = 5
+	dLen     = 6
+)
+
+type job struct {
+	target Target
+	items  []Item
+}
+
+// Notifier delivers the items passed to Notify in the background,
+// retrying failed deliveries with exponential backoff.
+type Notifier struct {
+	Logger  *log.Logger
+	Targets []Target
+	// DryRun logs the payloads instead of sending them.
+	DryRun   bool
+	Client   *http.Client
+	Attempts int
+	Backoff  time.Duration
+
+	file *os.File
+
+	initOnce sync.Once
+	queue    chan job
+	workers  worker.Group
+
+	mu  sync.Mutex
+	log []Delivery
+}
+
+// Open returns a Notifier that keeps its delivery log in dir.
+func Open(dir string) (*Notifier, error) {
+	f, err := os.OpenFile(filepath.Join(dir, LogFile), os.O_RDWR|os.O_CREATE|os.O_SYNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	n := &Notifier{file: f}
+	if err := n.loadLog(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("notify: %s: %v", LogFile, err)
+	}
+	return n, nil
+}
+
+func (n *Notifier) loadLog() error {
+	recs, err := csv.NewReader(n.file).ReadAll()
+	if err != nil {
+		return err
+	}
+	for _, r := range recs {
+		if len(r) != dLen {
+			return errors.New("unexpected row length")
+		}
+		d := Delivery{Target: r[dTarget], Err: r[dErr]}
+		if d.Time, err = time.Parse(time.RFC3339, r[dTime]); err != nil {
+			return err
+		}
+		if d.Items, err = strconv.Atoi(r[dItems]); err != nil {
+			return err
+		}
+		if d.Attempt, err = strconv.Atoi(r[dAttempt]); err != nil {
+			return err
+		}
+		if d.DryRun, err = strconv.ParseBool(r[dDryRun]); err != nil {
+			return err
+		}
+		n.log = append(n.log, d)
+	}
+	if len(n.log) > maxLog {
+		n.log = n.log[len(n.log)-maxLog:]
+		return n.writeLog(true, n.log...)
+	}
+	return nil
+}
+
+// writeLog appends deliveries to the log file, or replaces it.
+// The caller must hold n.mu.
+func (n *Notifier) writeLog(replace bool, deliveries ...Delivery) error {
+	recs := make([][]string, 0, len(deliveries))
+	for _, d := range deliveries {
+		r := make([]string, dLen)
+		r[dTime] = d.Time.Format(time.RFC3339)
+		r[dTarget] = d.Target
+		r[dItems] = strconv.Itoa(d.Items)
+		r[dAttempt] = strconv.Itoa(d.Attempt)
+		r[dErr] = d.Err
+		r[dDryRun] = strconv.FormatBool(d.DryRun)
+		recs = append(recs, r)
+	}
+	if replace {
+		if err := n.file.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := n.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	} else if _, err := n.file.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+	return csv.NewWriter(n.file).WriteAll(recs)
+}
+
+func (n *Notifier) record(d Delivery) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.log = append(n.log, d)
+	replace := false
+	if len(n.log) > 2*maxLog {
+		n.log = n.log[len(n.log)-maxLog:]
+		replace = true
+	}
+	var err error
+	if replace {
+		err = n.writeLog(true, n.log...)
+	} else {
+		err = n.writeLog(false, d)
+	}
+	if err != nil {
+		n.Logger.Printf("failed writing the delivery log %v", err)
+	}
+	if d.Err != "" {
+		n.Logger.Printf("failed notifying %s (attempt %d), %s", d.Target, d.Attempt, d.Err)
+	}
+}
+
+// Deliveries returns up to limit of the latest deliveries, newest first.
+func (n *Notifier) Deliveries(limit int) []Delivery {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	out := make([]Delivery, 0, limit)
+	for i := len(n.log) - 1; i >= 0 && len(out) < limit; i-- {
+		out = append(out, n.log[i])
+	}
+	return out
+}
+
+func (n *Notifier) init() {
+	n.initOnce.Do(func() {
+		if n.Logger == nil {
+			n.Logger = log.New(ioutil.Discard, "", 0)
+		}
+		if n.Client == nil {
+			n.Client = &http.Client{Timeout: DefaultTimeout}
+		}
+		if n.Attempts <= 0 {
+			n.Attempts = DefaultAttempts
+		}
+		if n.Backoff <= 0 {
+			n.Backoff = DefaultBackoff
+		}
+		n.queue = make(chan job, queueSize)
+	})
+}
+
+// Start delivers the queued items until Shutdown is called.
+func (n *Notifier) Start() {
+	n.init()
+	n.workers.Go(n.loop)
+}
+
+func (n *Notifier) loop() {
+	for {
+		select {
+		case j := <-n.queue:
+			n.deliver(j)
+		case <-n.workers.Stopping():
+			// Deliver what is queued, without retrying.
+			for {
+				select {
+				case j := <-n.queue:
+					n.deliver(j)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Notify queues the items of events for the targets that want them.
+// It does not block, items are dropped if the queue is full.
+func (n *Notifier) Notify(events ...Event) {
+	n.init()
+	for _, t := range n.Targets {
+		items := make([]Item, 0)
+		for _, e := range events {
+			for _, item := range e.Items {
+				if t.wants(item) {
+					items = append(items, newItem(e.Feed, item))
+				}
+			}
+		}
+		if len(items) == 0 {
+			continue
+		}
+		select {
+		case n.queue <- job{target: t, items: items}:
+		default:
+			n.record(Delivery{Time: time.Now(), Target: t.Name, Items: len(items), Err: "queue is full"})
+		}
+	}
+}
+
+func newItem(feed db.Feed, item db.Item) Item {
+	return Item{
+		ID:       item.ID,
+		FeedID:   item.FeedID,
+		FeedName: feed.DisplayName(),
+		Title:    item.Title,
+		URL:      item.URL,
+		Added:    item.Added,
+		Tags:     item.Tags,
+	}
+}
+
+func (n *Notifier) deliver(j job) {
+	for attempt := 1; ; attempt++ {
+		err := n.send(n.workers.Context(), j.target, j.items)
+		d := Delivery{Time: time.Now(), Target: j.target.Name, Items: len(j.items), Attempt: attempt, DryRun: n.DryRun}
+		if err != nil {
+			d.Err = err.Error()
+		}
+		n.record(d)
+		if err == nil || attempt >= n.Attempts || !retryable(err) {
+			return
+		}
+
+		t := time.NewTimer(n.Backoff << (attempt - 1))
+		select {
+		case <-t.C:
+		case <-n.workers.Stopping():
+			t.Stop()
+			return
+		}
+	}
+}
+
+// Test delivers a sample item to the target with the name
+// and waits for the result.
+func (n *Notifier) Test(ctx context.Context, name string) error {
+	n.init()
+	for _, t := range n.Targets {
+		if t.Name != name {
+			continue
+		}
+		item := Item{
+			FeedName: "feeder",
+			Title:    "Test notification from feeder",
+			URL:      "https://github.com/erikfastermann/feeder",
+			Added:    time.Now(),
+		}
+		err := n.send(ctx, t, []Item{item})
+		d := Delivery{Time: time.Now(), Target: t.Name, Items: 1, Attempt: 1, DryRun: n.DryRun}
+		if err != nil {
+			d.Err = err.Error()
+		}
+		n.record(d)
+		return err
+	}
+	return fmt.Errorf("notify: unknown target %s", name)
+}
+
+// Shutdown stops the deliveries. Queued items are delivered once more
+// without retries, unless ctx expires first. The log file is closed.
+func (n *Notifier) Shutdown(ctx context.Context) error {
+	err := n.workers.Shutdown(ctx)
+	n.mu.Lock()
+	n.file.Close()
+	n.mu.Unlock()
+	return err
+}
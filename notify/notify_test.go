@@ -0,0 +1,216 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/erikfastermann/feeder/db"
+	"github.com/erikfastermann/feeder/internal/smtptest"
+)
+
+// waitDeliveries waits until n has logged want deliveries.
+func waitDeliveries(t *testing.T, n *Notifier, want int) []Delivery {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		d := n.Deliveries(maxLog)
+		if len(d) >= want {
+			return d
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d deliveries, want %d: %+v", len(d), want, d)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	const secret = "hunter2"
+	var mu sync.Mutex
+	requests := 0
+	var got []Item
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if requests == 1 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if sig := Sign(secret, r.Header.Get(HeaderTimestamp), body); sig != r.Header.Get(HeaderSignature) {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+		var payload struct{ Items []Item }
+		if err := json.Unmarshal(body, &payload); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		got = append(got, payload.Items...)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	n, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Backoff = time.Millisecond
+	n.Targets = []Target{
+		{Name: "hook", Type: TypeWebhook, URL: srv.URL, Secret: secret, Feeds: []int{1}, Tags: []string{"urgent"}},
+		{Name: "wrong", Type: TypeWebhook, URL: srv.URL, Secret: "wrong", Feeds: []int{3}},
+	}
+	n.Start()
+
+	feed1 := db.Feed{ID: 1, Name: "one"}
+	feed2 := db.Feed{ID: 2, Name: "two"}
+	n.Notify(
+		Event{Feed: feed1, Items: []db.Item{{ID: 1, FeedID: 1, Title: "a", URL: "https://example.com/a"}}},
+		Event{Feed: feed2, Items: []db.Item{
+			{ID: 2, FeedID: 2, Title: "b", URL: "https://example.com/b"},
+			{ID: 3, FeedID: 2, Title: "c", URL: "https://example.com/c", Tags: []string{"urgent"}},
+		}},
+	)
+	deliveries := waitDeliveries(t, n, 2)
+	if deliveries[0].Err != "" || deliveries[0].Attempt != 2 || deliveries[0].Items != 2 || deliveries[1].Err == "" {
+		t.Fatalf("unexpected deliveries %+v", deliveries)
+	}
+	mu.Lock()
+	if len(got) != 2 || got[0].Title != "a" || got[0].FeedName != "one" || got[1].Title != "c" {
+		t.Fatalf("unexpected items %+v", got)
+	}
+	mu.Unlock()
+
+	// Bad signatures are not retried.
+	n.Notify(Event{Feed: db.Feed{ID: 3}, Items: []db.Item{{ID: 4, FeedID: 3, Title: "d"}}})
+	deliveries = waitDeliveries(t, n, 3)
+	time.Sleep(20 * time.Millisecond)
+	if d := n.Deliveries(maxLog); len(d) != 3 || d[0].Target != "wrong" || !strings.Contains(d[0].Err, "401") {
+		t.Fatalf("unexpected deliveries %+v", d)
+	}
+
+	if err := n.Test(context.Background(), "hook"); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Test(context.Background(), "missing"); err == nil {
+		t.Fatal("tested an unknown target")
+	}
+	if err := n.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown(context.Background())
+	if d := n.Deliveries(maxLog); len(d) != 4 || d[3].Err == "" || d[2].Attempt != 2 {
+		t.Fatalf("log not stored, got %+v", d)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("dry run sent a request")
+	}))
+	defer srv.Close()
+
+	n, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown(context.Background())
+	n.DryRun = true
+	n.Targets = []Target{{Name: "slack", Type: TypeSlack, URL: srv.URL}}
+	if err := n.Test(context.Background(), "slack"); err != nil {
+		t.Fatal(err)
+	}
+	if d := n.Deliveries(1); len(d) != 1 || !d[0].DryRun || d[0].Err != "" {
+		t.Fatalf("unexpected deliveries %+v", d)
+	}
+}
+
+func TestFormats(t *testing.T) {
+	items := []Item{{Title: "<b> & [x]", URL: "https://example.com/?a=1&b=2", FeedName: "f_1"}}
+	if got, want := slackText(items), "<https://example.com/?a=1&amp;b=2|&lt;b&gt; &amp; [x]> (f_1)"; got != want {
+		t.Errorf("slack: got %q, want %q", got, want)
+	}
+	if got, want := discordContent(items), `[<b> & \[x\]](<https://example.com/?a=1&b=2>) (f\_1)`; got != want {
+		t.Errorf("discord: got %q, want %q", got, want)
+	}
+	if _, got := matrixText(items); !strings.Contains(got, `<a href="https://example.com/?a=1&amp;b=2">&lt;b&gt; &amp; [x]</a>`) {
+		t.Errorf("matrix: got %q", got)
+	}
+
+	many := make([]Item, 100)
+	for i := range many {
+		many[i] = Item{Title: strings.Repeat("x", 50), URL: "https://example.com/"}
+	}
+	if got := discordContent(many); len(got) > discordMax || !strings.HasSuffix(got, "more") {
+		t.Errorf("discord message of %d bytes: ...%s", len(got), got[len(got)-20:])
+	}
+}
+
+func TestEmail(t *testing.T) {
+	s := smtptest.NewServer(t)
+	n, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown(context.Background())
+	n.Targets = []Target{{
+		Name: "mail",
+		Type: TypeEmail,
+		SMTP: SMTP{Addr: s.Addr, From: "feeder@example.com"},
+		To:   []string{"Jane <jane@example.com>"},
+	}}
+	n.Start()
+	n.Notify(Event{Feed: db.Feed{ID: 1, Name: "Blög"}, Items: []db.Item{
+		{ID: 1, FeedID: 1, Title: "Grüße", URL: "https://example.com/1"},
+		{ID: 2, FeedID: 1, Title: "second", URL: "https://example.com/2"},
+	}})
+	if d := waitDeliveries(t, n, 1); d[0].Err != "" {
+		t.Fatalf("unexpected deliveries %+v", d)
+	}
+
+	msgs, rcpts := s.Messages(), s.Recipients()
+	if len(msgs) != 1 || len(rcpts) != 1 || rcpts[0] != "<jane@example.com>" {
+		t.Fatalf("got messages %q for %q", msgs, rcpts)
+	}
+	msg := msgs[0]
+	for _, want := range []string{"Subject: 2 new items", "To: Jane <jane@example.com>", "Gr=C3=BC=C3=9Fe", "https://example.com/2", "Bl=C3=B6g"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message without %q\n%s", want, msg)
+		}
+	}
+}
+
+func TestLoadTargets(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		config string
+		ok     bool
+	}{
+		{`[{"name": "a", "type": "slack", "url": "https://hooks.slack.com/x"}]`, true},
+		{`[{"name": "a", "type": "webhook", "url": "https://example.com"}]`, false},
+		{`[{"name": "a", "type": "discord", "url": "ftp://example.com"}]`, false},
+		{`[{"name": "a", "type": "email", "smtp": {"addr": "localhost:25"}, "to": ["a@example.com"]}]`, false},
+		{`[{"name": "a", "type": "sms"}]`, false},
+		{`[{"name": "a", "type": "matrix", "url": "https://m.example.com"}, {"name": "a", "type": "matrix", "url": "https://m.example.com"}]`, false},
+	} {
+		path := dir + "/targets.json"
+		if err := ioutil.WriteFile(path, []byte(tc.config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadTargets(path); (err == nil) != tc.ok {
+			t.Errorf("%s: got error %v", tc.config, err)
+		}
+	}
+}
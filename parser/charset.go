@@ -0,0 +1,90 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"mime"
+	"regexp"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+)
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+)
+
+// prologEncoding matches the encoding declared in the XML prolog.
+var prologEncoding = regexp.MustCompile(`^\s*<\?xml[^>]*?encoding\s*=\s*["']([A-Za-z0-9._:-]+)["']`)
+
+// toUTF8 transcodes the feed to UTF-8. The encoding is taken from the
+// byte order mark, the charset of the Content-Type header or the XML
+// prolog, in that order, and defaults to UTF-8. Unknown encodings are
+// treated as UTF-8, invalid sequences are replaced and control
+// characters that are not allowed in XML are removed.
+func toUTF8(data []byte, contentType string) []byte {
+	label := ""
+	switch {
+	case bytes.HasPrefix(data, bomUTF8):
+		data, label = data[len(bomUTF8):], "utf-8"
+	case bytes.HasPrefix(data, bomUTF16BE):
+		data, label = data[len(bomUTF16BE):], "utf-16be"
+	case bytes.HasPrefix(data, bomUTF16LE):
+		data, label = data[len(bomUTF16LE):], "utf-16le"
+	}
+	if label == "" {
+		if _, params, err := mime.ParseMediaType(contentType); err == nil {
+			label = params["charset"]
+		}
+	}
+	if label == "" {
+		if m := prologEncoding.FindSubmatch(data); m != nil {
+			label = string(m[1])
+		}
+	}
+
+	if e, name := charset.Lookup(label); e != nil && name != "utf-8" {
+		if decoded, err := e.NewDecoder().Bytes(data); err == nil {
+			data = decoded
+		}
+	}
+	return bytes.Map(func(r rune) rune {
+		if !isXMLChar(r) {
+			return -1
+		}
+		return r
+	}, bytes.ToValidUTF8(data, []byte(string(utf8.RuneError))))
+}
+
+// isXMLChar reports whether r may appear in an XML 1.0 document.
+// The byte order mark is not allowed inside of the document either.
+func isXMLChar(r rune) bool {
+	switch {
+	case r == '\t' || r == '\n' || r == '\r':
+		return true
+	case r < 0x20:
+		return false
+	case r >= 0xD800 && r <= 0xDFFF:
+		return false
+	case r == 0xFEFF || r == 0xFFFE || r == 0xFFFF:
+		return false
+	}
+	return r <= utf8.MaxRune
+}
+
+// unmarshal decodes UTF-8 data leniently: HTML entities are known,
+// unknown ones and other mistakes of hand written feeds are tolerated.
+func unmarshal(data []byte, v interface{}) error {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+	d.Entity = xml.HTMLEntity
+	// The data was already transcoded by toUTF8,
+	// the prolog may still declare the original encoding.
+	d.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	return d.Decode(v)
+}
@@ -0,0 +1,43 @@
+package parser
+
+import "testing"
+
+func TestCharset(t *testing.T) {
+	rss := func(prolog, title string) []byte {
+		return []byte(prolog + "<rss><channel><item><title>" + title +
+			"</title><link>https://example.com/</link></item></channel></rss>")
+	}
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+		want        string
+	}{
+		{"utf-8", rss(`<?xml version="1.0"?>`, "café"), "", "café"},
+		{"latin1 prolog", rss(`<?xml version="1.0" encoding="ISO-8859-1"?>`, "caf\xe9"), "", "café"},
+		{"latin1 header", rss(`<?xml version="1.0"?>`, "caf\xe9"), "application/rss+xml; charset=iso-8859-1", "café"},
+		{"header over prolog", rss(`<?xml version="1.0" encoding="utf-8"?>`, "caf\xe9"), "text/xml; charset=ISO-8859-1", "café"},
+		{"windows-1252", rss(`<?xml version='1.0' encoding='windows-1252'?>`, "\x93quoted\x94 \x80"), "", "“quoted” €"},
+		{"shift_jis", rss(`<?xml version="1.0" encoding="Shift_JIS"?>`, "\x93\xfa\x96\x7b"), "", "日本"},
+		{"koi8-r", rss(`<?xml version="1.0" encoding="KOI8-R"?>`, "\xf0\xd2\xc9\xd7\xc5\xd4"), "", "Привет"},
+		{"utf-8 bom", append([]byte("\xef\xbb\xbf"), rss(`<?xml version="1.0" encoding="ISO-8859-1"?>`, "café")...), "", "café"},
+		{"utf-16le bom", []byte("\xff\xfe<\x00r\x00s\x00s\x00>\x00<\x00c\x00h\x00a\x00n\x00n\x00e\x00l\x00>\x00" +
+			"<\x00i\x00t\x00e\x00m\x00>\x00<\x00t\x00i\x00t\x00l\x00e\x00>\x00\xe9\x00<\x00/\x00t\x00i\x00t\x00l\x00e\x00>\x00" +
+			"<\x00l\x00i\x00n\x00k\x00>\x00x\x00<\x00/\x00l\x00i\x00n\x00k\x00>\x00" +
+			"<\x00/\x00i\x00t\x00e\x00m\x00>\x00<\x00/\x00c\x00h\x00a\x00n\x00n\x00e\x00l\x00>\x00<\x00/\x00r\x00s\x00s\x00>\x00"), "", "é"},
+		{"html entities", rss("", "a&nbsp;b &mdash; &copy; &amp;"), "", "a b — © &"},
+		{"unknown entity", rss("", "a &bogus; b"), "", "a &bogus; b"},
+		{"control characters", rss("", "a\x00b\x1bc\x0bd\tx"), "", "abcd\tx"},
+		{"invalid utf-8", rss("", "a\xffb"), "", "a�b"},
+	}
+	for _, tt := range tests {
+		feed, err := parseFeed(toUTF8(tt.data, tt.contentType))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(feed.Items) != 1 || feed.Items[0].Title != tt.want {
+			t.Errorf("%s: got %+v, want title %q", tt.name, feed.Items, tt.want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// zones maps the timezone abbreviations found in feeds to their offsets,
+// time.Parse only knows the offsets of the local timezone.
+var zones = map[string]string{
+	"Z": "+0000", "UT": "+0000", "UTC": "+0000", "GMT": "+0000",
+	"EST": "-0500", "EDT": "-0400", "CST": "-0600", "CDT": "-0500",
+	"MST": "-0700", "MDT": "-0600", "PST": "-0800", "PDT": "-0700",
+	"AKST": "-0900", "AKDT": "-0800", "HST": "-1000",
+	"WET": "+0000", "WEST": "+0100", "BST": "+0100",
+	"CET": "+0100", "CEST": "+0200", "MET": "+0100", "MEST": "+0200",
+	"EET": "+0200", "EEST": "+0300", "MSK": "+0300",
+	"JST": "+0900", "KST": "+0900", "AWST": "+0800",
+	"ACST": "+0930", "AEST": "+1000", "AEDT": "+1100",
+	"NZST": "+1200", "NZDT": "+1300",
+}
+
+var weekdays = map[string]bool{
+	"mon": true, "tue": true, "wed": true, "thu": true,
+	"fri": true, "sat": true, "sun": true,
+}
+
+// isoSpace matches an ISO 8601 date followed by a space and the time.
+var isoSpace = regexp.MustCompile(`^\d{4}-\d{2}-\d{2} \d`)
+
+// layouts are tried in order on the normalized date,
+// see normalizeDate.
+var layouts = func() []string {
+	layouts := []string{
+		"2006-01-02T15:04:05Z07:00",
+		"2006-01-02T15:04:05-0700",
+		"2006-01-02T15:04:05 -0700",
+		"2006-01-02T15:04:05",
+		"2006-01-02T15:04Z07:00",
+		"2006-01-02T15:04-0700",
+		"2006-01-02T15:04",
+		"2006-01-02",
+		"Jan 2 15:04:05 -0700 2006", // time.UnixDate
+		"Jan 2 15:04:05 MST 2006",
+		"Jan 2 15:04:05 2006", // time.ANSIC
+	}
+	dates := []string{"2 Jan 2006", "2 Jan 06", "2 January 2006", "Jan 2 2006", "January 2 2006"}
+	times := []string{"15:04:05", "15:04"}
+	zones := []string{" -0700", " -07:00", " MST", ""}
+	for _, d := range dates {
+		for _, t := range times {
+			for _, z := range zones {
+				layouts = append(layouts, d+" "+t+z)
+			}
+		}
+		layouts = append(layouts, d)
+	}
+	return layouts
+}()
+
+// normalizeDate collapses whitespace and removes the parts of a date
+// that vary between feeds without adding information: the weekday,
+// commas, comments and timezone abbreviations, which are replaced
+// by their offset if known.
+func normalizeDate(str string) string {
+	fields := strings.Fields(strings.ReplaceAll(str, ",", " "))
+	if len(fields) > 0 {
+		f := strings.ToLower(fields[0])
+		if len(f) >= 3 && weekdays[f[:3]] && strings.Trim(f, "abcdefghijklmnopqrstuvwxyz.") == "" {
+			fields = fields[1:]
+		}
+	}
+	if n := len(fields); n > 1 && strings.HasPrefix(fields[n-1], "(") && strings.HasSuffix(fields[n-1], ")") {
+		fields = fields[:n-1]
+	}
+	for i, f := range fields {
+		if offset, ok := zones[strings.ToUpper(f)]; ok && i > 0 {
+			fields[i] = offset
+		}
+		if strings.EqualFold(f, "Sept") {
+			fields[i] = "Sep"
+		}
+	}
+
+	s := strings.Join(fields, " ")
+	if isoSpace.MatchString(s) {
+		s = s[:10] + "T" + s[11:]
+	}
+	return s
+}
+
+// parseDate parses the dates found in feeds, mostly RFC 822 and
+// ISO 8601 with their common deviations. Dates without a timezone are
+// assumed to be UTC.
+func parseDate(str string) (time.Time, error) {
+	s := normalizeDate(str)
+	if s == "" {
+		return time.Time{}, errors.New("time is empty")
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("time %s is invalid", strconv.Quote(str))
+}
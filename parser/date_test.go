@@ -0,0 +1,79 @@
+package parser
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDate(t *testing.T) {
+	est := time.FixedZone("", -5*60*60)
+	pdt := time.FixedZone("", -7*60*60)
+	cest := time.FixedZone("", 2*60*60)
+
+	// Taken from real feeds.
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"Mon, 02 Jan 2006 15:04:05 GMT", time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"Mon, 02 Jan 2006 15:04:05 -0500", time.Date(2006, 1, 2, 15, 4, 5, 0, est)},
+		{"Tue, 2 Jan 2006 15:04:05 +0000", time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"Wed, 12 Jun 2019 07:30:00 PDT", time.Date(2019, 6, 12, 7, 30, 0, 0, pdt)},
+		{"Sat, 07 Sep 02 00:00:01 GMT", time.Date(2002, 9, 7, 0, 0, 1, 0, time.UTC)},
+		{"Fri, 01 Mar 19 14:30 EST", time.Date(2019, 3, 1, 14, 30, 0, 0, est)},
+		{"Thursday, 03 Sept 2020 10:00:00 CEST", time.Date(2020, 9, 3, 10, 0, 0, 0, cest)},
+		{"Mon, 02 Jan 2006 15:04:05 +02:00", time.Date(2006, 1, 2, 15, 4, 5, 0, cest)},
+		{"Mon, 02 Jan 2006 15:04:05 -0500 (EST)", time.Date(2006, 1, 2, 15, 4, 5, 0, est)},
+		{"02 Jan 2006 15:04:05", time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"  \n\tMon, 02 Jan 2006\n 15:04:05 GMT \n", time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"January 2, 2006", time.Date(2006, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"Jan 2, 2006 3:04 -0500", time.Date(2006, 1, 2, 3, 4, 0, 0, est)},
+		{"Mon Jan  2 15:04:05 EST 2006", time.Date(2006, 1, 2, 15, 4, 5, 0, est)},
+		{"2006-01-02T15:04:05Z", time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"2006-01-02T15:04:05.123+02:00", time.Date(2006, 1, 2, 15, 4, 5, 123e6, cest)},
+		{"2006-01-02T15:04:05-0500", time.Date(2006, 1, 2, 15, 4, 5, 0, est)},
+		{"2006-01-02T15:04:05", time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"2006-01-02T15:04Z", time.Date(2006, 1, 2, 15, 4, 0, 0, time.UTC)},
+		{"2006-01-02 15:04:05", time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"2006-01-02 15:04:05 UTC", time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"2006-01-02", time.Date(2006, 1, 2, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := parseDate(tt.in)
+		if err != nil {
+			t.Errorf("parseDate(%q): %v", tt.in, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseDate(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "   ", "yesterday", "32 Jan 2006", "2006-13-01"} {
+		if got, err := parseDate(in); err == nil {
+			t.Errorf("parseDate(%q) = %v, want an error", in, got)
+		}
+	}
+}
+
+func TestParseFallbackDate(t *testing.T) {
+	before := time.Now()
+	feed, err := parseFeed([]byte(`<rss><channel>
+<item><link>https://example.com/1</link><pubDate>not a date</pubDate></item>
+<item><link>https://example.com/2</link><pubDate>Mon, 02 Jan 2106 15:04:05 GMT</pubDate></item>
+<item><link>https://example.com/3</link><pubDate>Mon, 02 Jan 2006 15:04:05 GMT</pubDate></item>
+</channel></rss>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := time.Now()
+
+	for _, item := range feed.Items[:2] {
+		if item.Added.Before(before) || item.Added.After(after) {
+			t.Errorf("%s: got %v, want the time of parsing", item.URL, item.Added)
+		}
+	}
+	if want := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC); !feed.Items[2].Added.Equal(want) {
+		t.Errorf("got %v, want %v", feed.Items[2].Added, want)
+	}
+}
@@ -0,0 +1,301 @@
+package parser
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	UserAgent = "feeder (+https://github.com/erikfastermann/feeder)"
+
+	DefaultTimeout = 10 * time.Second
+
+	// MaxBodySize is the largest feed in bytes, after decompression.
+	MaxBodySize = 10 << 20
+
+	maxRedirects = 10
+)
+
+var ErrTooLarge = fmt.Errorf("parser: feed is larger than %d bytes", MaxBodySize)
+
+// StatusError is returned if the server does not answer with 200 OK.
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("parser: %s returned %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Gone reports whether the feed was removed for good.
+func (e *StatusError) Gone() bool {
+	return e.StatusCode == http.StatusGone
+}
+
+// IsGone reports whether err is a StatusError of a feed that was removed.
+func IsGone(err error) bool {
+	var se *StatusError
+	return errors.As(err, &se) && se.Gone()
+}
+
+// ContentTypeError is returned if the server sent something other than a feed.
+type ContentTypeError struct {
+	URL         string
+	ContentType string
+}
+
+func (e *ContentTypeError) Error() string {
+	return fmt.Sprintf("parser: %s is not a feed but %s", e.URL, e.ContentType)
+}
+
+func mediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return mt
+}
+
+func isHTML(contentType string) bool {
+	mt := mediaType(contentType)
+	return mt == "text/html" || mt == "application/xhtml+xml"
+}
+
+// acceptable reports whether contentType may be a feed. Feeds are served
+// with all kinds of XML, JSON and text types, or without one.
+func acceptable(contentType string) bool {
+	mt := mediaType(contentType)
+	return mt == "" || strings.HasPrefix(mt, "text/") ||
+		strings.Contains(mt, "xml") || strings.Contains(mt, "json") ||
+		mt == "application/octet-stream"
+}
+
+// Fetcher sends the requests of a Parser, *http.Client implements it.
+type Fetcher interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// HTTPConfig configures the client returned by NewClient.
+type HTTPConfig struct {
+	// Timeout limits a whole fetch, including redirects and the body.
+	Timeout time.Duration
+	// Proxy is the URL of an HTTP, HTTPS or SOCKS5 proxy.
+	Proxy *url.URL
+	// RootCAs replaces the certificate authorities of the system.
+	RootCAs *x509.CertPool
+	// Policy restricts the addresses the client connects to.
+	// With a proxy, the URLs are checked before each request instead,
+	// as the proxy resolves the hosts itself.
+	Policy *Policy
+	// CheckProxy applies the Policy to the connections to the Proxy
+	// as well, for proxies that are not set by the operator.
+	CheckProxy bool
+}
+
+// NewClient returns a client for the config. Its connections are
+// reused, so one client should be shared by everything that fetches.
+func NewClient(c HTTPConfig) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if c.Policy != nil && (c.Proxy == nil || c.CheckProxy) {
+		dialer.Control = c.Policy.control
+	}
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	if c.Proxy != nil {
+		transport.Proxy = http.ProxyURL(c.Proxy)
+	}
+	if c.RootCAs != nil {
+		transport.TLSClientConfig = &tls.Config{RootCAs: c.RootCAs}
+	}
+
+	var rt http.RoundTripper = transport
+	if c.Policy != nil && c.Proxy != nil {
+		rt = policyTransport{policy: c.Policy, base: transport}
+	}
+	return &http.Client{
+		Transport: rt,
+		Timeout:   c.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("parser: stopped after %d redirects", maxRedirects)
+			}
+			return checkScheme(req.URL)
+		},
+	}
+}
+
+var (
+	defaultFetcherOnce sync.Once
+	defaultFetcher     Fetcher
+)
+
+// Parser fetches and parses feeds.
+type Parser struct {
+	// Fetcher defaults to a client with DefaultTimeout
+	// and a Policy without exceptions.
+	Fetcher Fetcher
+	// Config is used for the clients of feeds with their own proxy.
+	Config HTTPConfig
+
+	mu      sync.Mutex
+	proxies map[string]Fetcher
+}
+
+func (p *Parser) fetcher() Fetcher {
+	if p.Fetcher != nil {
+		return p.Fetcher
+	}
+	defaultFetcherOnce.Do(func() {
+		defaultFetcher = NewClient(HTTPConfig{Timeout: DefaultTimeout, Policy: &Policy{}})
+	})
+	return defaultFetcher
+}
+
+type response struct {
+	data        []byte
+	contentType string
+	// permanentURL is set if all redirects were permanent.
+	permanentURL string
+	// hub and self are the WebSub links in the Link header.
+	hub, self string
+}
+
+// linkHeader returns the hub and the self link in Link header values,
+// like <https://hub.example.com/>; rel="hub".
+func linkHeader(values []string) (hub, self string) {
+	for _, v := range values {
+		for _, l := range strings.Split(v, ",") {
+			parts := strings.Split(l, ";")
+			href := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(href, "<") || !strings.HasSuffix(href, ">") {
+				continue
+			}
+			href = href[1 : len(href)-1]
+			for _, param := range parts[1:] {
+				name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+				if !ok || !strings.EqualFold(strings.TrimSpace(name), "rel") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(strings.TrimSpace(value), `"`)) {
+					switch {
+					case strings.EqualFold(rel, "hub") && hub == "":
+						hub = href
+					case strings.EqualFold(rel, "self") && self == "":
+						self = href
+					}
+				}
+			}
+		}
+	}
+	return hub, self
+}
+
+// permanentURL returns the URL of the final request of res,
+// if it was reached only through permanent redirects.
+func permanentURL(res *http.Response) string {
+	if res.Request.Response == nil {
+		return ""
+	}
+	for r := res.Request.Response; r != nil; r = r.Request.Response {
+		if r.StatusCode != http.StatusMovedPermanently && r.StatusCode != http.StatusPermanentRedirect {
+			return ""
+		}
+	}
+	return res.Request.URL.String()
+}
+
+func (p *Parser) fetch(ctx context.Context, url string, opts Options) (*response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkScheme(req.URL); err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", UserAgent)
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/xml;q=0.9, text/xml;q=0.9, */*;q=0.8")
+	// Setting Accept-Encoding disables the transparent gzip support
+	// of the transport, both encodings are decoded below.
+	req.Header.Set("Accept-Encoding", "gzip, br")
+	if err := opts.apply(req); err != nil {
+		return nil, err
+	}
+	fetcher := p.fetcher()
+	proxy, err := opts.proxy()
+	if err != nil {
+		return nil, err
+	}
+	if proxy != nil {
+		fetcher = p.proxied(proxy)
+	}
+
+	res, err := fetcher.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, &StatusError{URL: url, StatusCode: res.StatusCode}
+	}
+	contentType := res.Header.Get("Content-Type")
+	if !acceptable(contentType) {
+		return nil, &ContentTypeError{URL: url, ContentType: contentType}
+	}
+
+	var body io.Reader = res.Body
+	switch enc := strings.ToLower(strings.TrimSpace(res.Header.Get("Content-Encoding"))); enc {
+	case "", "identity":
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(res.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		body = gz
+	case "br":
+		body = brotli.NewReader(res.Body)
+	default:
+		return nil, fmt.Errorf("parser: unsupported content encoding %s", enc)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(body, MaxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxBodySize {
+		return nil, ErrTooLarge
+	}
+
+	r := &response{data: data, contentType: contentType}
+	r.hub, r.self = linkHeader(res.Header.Values("Link"))
+	if u := permanentURL(res); u != url {
+		r.permanentURL = u
+	}
+	return r, nil
+}
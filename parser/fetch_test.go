@@ -0,0 +1,178 @@
+package parser
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+const testFeed = `<rss><channel><item><link>https://example.com/1</link></item></channel></rss>`
+
+func TestFetch(t *testing.T) {
+	var userAgent string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/feed", func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.UserAgent()
+		w.Write([]byte(testFeed))
+	})
+	mux.HandleFunc("/gzip", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		gz.Write([]byte(testFeed))
+		gz.Close()
+	})
+	mux.HandleFunc("/br", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "br")
+		br := brotli.NewWriter(w)
+		br.Write([]byte(testFeed))
+		br.Close()
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		gz.Write(bytes.Repeat([]byte(" "), MaxBodySize+1))
+		gz.Close()
+	})
+	mux.HandleFunc("/html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<html><body>Not found</body></html>"))
+	})
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+	})
+	mux.HandleFunc("/gone", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone", http.StatusGone)
+	})
+	mux.Handle("/moved", http.RedirectHandler("/moved-again", http.StatusMovedPermanently))
+	mux.Handle("/moved-again", http.RedirectHandler("/feed", http.StatusPermanentRedirect))
+	mux.Handle("/found", http.RedirectHandler("/moved", http.StatusFound))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	p := &Parser{Fetcher: srv.Client()}
+	ctx := context.Background()
+	for _, path := range []string{"/feed", "/gzip", "/br"} {
+		feed, err := p.Parse(ctx, srv.URL+path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if len(feed.Items) != 1 || feed.PermanentURL != "" {
+			t.Fatalf("%s: got %+v", path, feed)
+		}
+	}
+	if userAgent != UserAgent {
+		t.Errorf("got user agent %q, want %q", userAgent, UserAgent)
+	}
+
+	if _, err := p.Parse(ctx, srv.URL+"/large"); err != ErrTooLarge {
+		t.Errorf("got %v, want %v", err, ErrTooLarge)
+	}
+	for _, path := range []string{"/html", "/image"} {
+		if _, err := p.Parse(ctx, srv.URL+path); err == nil {
+			t.Errorf("%s: got no error", path)
+		} else if _, ok := err.(*ContentTypeError); !ok {
+			t.Errorf("%s: got %v, want a ContentTypeError", path, err)
+		}
+	}
+
+	_, err := p.Parse(ctx, srv.URL+"/missing")
+	if se, ok := err.(*StatusError); !ok || se.StatusCode != http.StatusNotFound || IsGone(err) {
+		t.Errorf("got %v, want a 404 StatusError", err)
+	}
+	if _, err := p.Parse(ctx, srv.URL+"/gone"); !IsGone(err) {
+		t.Errorf("got %v, want gone", err)
+	}
+
+	feed, err := p.Parse(ctx, srv.URL+"/moved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feed.PermanentURL != srv.URL+"/feed" {
+		t.Errorf("got permanent URL %q, want %q", feed.PermanentURL, srv.URL+"/feed")
+	}
+	feed, err = p.Parse(ctx, srv.URL+"/found")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feed.PermanentURL != "" {
+		t.Errorf("got permanent URL %q after a temporary redirect", feed.PermanentURL)
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	err := &StatusError{URL: "https://example.com/feed", StatusCode: http.StatusInternalServerError}
+	if !strings.Contains(err.Error(), "500 Internal Server Error") {
+		t.Errorf("got %q", err)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+		w.Write([]byte(testFeed))
+	}))
+	defer srv.Close()
+	p := &Parser{Fetcher: srv.Client()}
+	ctx := context.Background()
+
+	opts := Options{
+		Username:  "user",
+		Password:  "pass",
+		Cookie:    "session=1",
+		UserAgent: "custom",
+		Header:    "X-Api-Key: secret\n\nx-other:  value ",
+	}
+	if _, err := p.ParseWith(ctx, srv.URL, opts); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"Authorization": "Basic dXNlcjpwYXNz",
+		"Cookie":        "session=1",
+		"User-Agent":    "custom",
+		"X-Api-Key":     "secret",
+		"X-Other":       "value",
+	} {
+		if got.Get(name) != want {
+			t.Errorf("got %s %q, want %q", name, got.Get(name), want)
+		}
+	}
+
+	opts.Token = "token"
+	if _, err := p.ParseWith(ctx, srv.URL, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got.Get("Authorization") != "Bearer token" {
+		t.Errorf("got Authorization %q, want the bearer token", got.Get("Authorization"))
+	}
+
+	for _, invalid := range []Options{{Header: "no colon"}, {Proxy: "ftp://proxy"}, {Proxy: "http://"}} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("%+v is valid", invalid)
+		}
+	}
+}
+
+func TestLinkHeader(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Link", `<https://example.com/next>; rel="next", <https://hub.example.com/>; rel=hub`)
+		w.Header().Add("Link", `<https://example.com/feed>; title="x"; rel="self alternate"`)
+		w.Write([]byte(`<feed xmlns="http://www.w3.org/2005/Atom"><link rel="hub" href="https://other.example.com/"/></feed>`))
+	}))
+	defer srv.Close()
+	p := &Parser{Fetcher: srv.Client()}
+
+	feed, err := p.Parse(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feed.Hub != "https://hub.example.com/" || feed.Self != "https://example.com/feed" {
+		t.Errorf("got hub %q and self %q", feed.Hub, feed.Self)
+	}
+}
@@ -0,0 +1,134 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/erikfastermann/feeder/db"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// golden is what a fixture in testdata is expected to parse to.
+type golden struct {
+	Meta     db.FeedMeta `json:",omitempty"`
+	Items    []db.Item   `json:",omitempty"`
+	Entries  []Entry     `json:",omitempty"`
+	Skipped  int         `json:",omitempty"`
+	Hub      string      `json:",omitempty"`
+	Self     string      `json:",omitempty"`
+	Warnings []string    `json:",omitempty"`
+	Error    string      `json:",omitempty"`
+}
+
+// fixtures returns the feeds in testdata, without the golden files.
+// The fixtures named unsupported-* are feeds in formats the parser
+// does not read, their golden files record the UnsupportedFormatError.
+func fixtures(t testing.TB) []string {
+	paths, err := filepath.Glob(filepath.Join("testdata", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	feeds := make([]string, 0)
+	for _, path := range paths {
+		if filepath.Ext(path) != ".golden" {
+			feeds = append(feeds, path)
+		}
+	}
+	return feeds
+}
+
+func TestGolden(t *testing.T) {
+	now := time.Date(2020, 12, 1, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	for _, path := range fixtures(t) {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got golden
+			feed, err := parseFeed(toUTF8(data, ""))
+			if err != nil {
+				got.Error = err.Error()
+			} else {
+				got.Meta, got.Items, got.Entries, got.Skipped = feed.FeedMeta, feed.Items, feed.Entries, feed.Skipped
+				got.Hub, got.Self = feed.Hub, feed.Self
+				for _, w := range feed.Warnings {
+					got.Warnings = append(got.Warnings, w.Error())
+				}
+			}
+			gotJSON, err := json.MarshalIndent(got, "", "\t")
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotJSON = append(gotJSON, '\n')
+
+			goldenPath := strings.TrimSuffix(path, filepath.Ext(path)) + ".golden"
+			if *update {
+				if err := ioutil.WriteFile(goldenPath, gotJSON, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := ioutil.ReadFile(goldenPath)
+			if err != nil {
+				t.Fatalf("%v, run the tests with -update to create it", err)
+			}
+			if !bytes.Equal(gotJSON, want) {
+				t.Errorf("got\n%s\nwant\n%s", gotJSON, want)
+			}
+		})
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, path := range fixtures(f) {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		feed, err := parseFeed(toUTF8(data, ""))
+		if err != nil {
+			return
+		}
+		for _, item := range feed.Items {
+			if item.URL == "" {
+				t.Fatalf("item without a URL %+v", item)
+			}
+		}
+		if len(feed.Entries) != len(feed.Items) {
+			t.Fatalf("got %d entries for %d items", len(feed.Entries), len(feed.Items))
+		}
+	})
+}
+
+func FuzzParseDate(f *testing.F) {
+	for _, s := range []string{
+		"Mon, 02 Jan 2006 15:04:05 MST",
+		"2006-01-02T15:04:05.999Z",
+		"Thursday, 16 Apr 2020 9:15 EST",
+		"2020-07-01 10:00:00",
+		"Sept 3 2019 (PDT)",
+		"",
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		parsed, err := parseDate(s)
+		if err != nil && !parsed.IsZero() {
+			t.Fatalf("parseDate(%q) returned %v with the error %v", s, parsed, err)
+		}
+	})
+}
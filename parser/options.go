@@ -0,0 +1,132 @@
+package parser
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+)
+
+// Options are sent with the requests for a single feed.
+type Options struct {
+	// Username and Password are sent with Basic Auth.
+	Username string `json:",omitempty"`
+	Password string `json:",omitempty"`
+	// Token is sent as a bearer token.
+	Token     string `json:",omitempty"`
+	Cookie    string `json:",omitempty"`
+	UserAgent string `json:",omitempty"`
+	// Header holds additional headers, one "Name: value" per line.
+	Header string `json:",omitempty"`
+	// Proxy is the URL of an HTTP, HTTPS or SOCKS5 proxy
+	// that is used instead of the one of the Parser.
+	Proxy string `json:",omitempty"`
+}
+
+// IsZero reports whether no options are set.
+func (o Options) IsZero() bool {
+	return o == Options{}
+}
+
+func (o Options) header() (http.Header, error) {
+	h := make(http.Header)
+	for _, line := range strings.Split(o.Header, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		i := strings.IndexByte(line, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("parser: invalid header line %q", line)
+		}
+		name := strings.TrimSpace(line[:i])
+		if strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("parser: invalid header name %q", name)
+		}
+		h.Add(textproto.CanonicalMIMEHeaderKey(name), strings.TrimSpace(line[i+1:]))
+	}
+	return h, nil
+}
+
+func (o Options) proxy() (*url.URL, error) {
+	if o.Proxy == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(o.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("parser: unsupported proxy %s", o.Proxy)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("parser: proxy %s without a host", o.Proxy)
+	}
+	return u, nil
+}
+
+// Validate returns an error if the header or proxy can't be used.
+func (o Options) Validate() error {
+	if _, err := o.header(); err != nil {
+		return err
+	}
+	_, err := o.proxy()
+	return err
+}
+
+// apply sets the options on the first request to a feed.
+// The client drops the credentials on redirects to other hosts.
+func (o Options) apply(req *http.Request) error {
+	h, err := o.header()
+	if err != nil {
+		return err
+	}
+	for name, values := range h {
+		req.Header[name] = values
+	}
+	if o.UserAgent != "" {
+		req.Header.Set("User-Agent", o.UserAgent)
+	}
+	if o.Cookie != "" {
+		req.Header.Set("Cookie", o.Cookie)
+	}
+	switch {
+	case o.Token != "":
+		req.Header.Set("Authorization", "Bearer "+o.Token)
+	case o.Username != "" || o.Password != "":
+		auth := base64.StdEncoding.EncodeToString([]byte(o.Username + ":" + o.Password))
+		req.Header.Set("Authorization", "Basic "+auth)
+	}
+	return nil
+}
+
+// proxied returns the fetcher for feeds with their own proxy.
+// The clients are created from Config and kept for reuse.
+// Unlike the proxy of Config, the proxies are set by users,
+// so the connections to them are checked by the Policy as well.
+func (p *Parser) proxied(proxy *url.URL) Fetcher {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.proxies == nil {
+		p.proxies = make(map[string]Fetcher)
+	}
+	if f, ok := p.proxies[proxy.String()]; ok {
+		return f
+	}
+	c := p.Config
+	if c.Timeout == 0 {
+		c.Timeout = DefaultTimeout
+	}
+	if c.Policy == nil {
+		c.Policy = &Policy{}
+	}
+	c.Proxy = proxy
+	c.CheckProxy = true
+	f := NewClient(c)
+	p.proxies[proxy.String()] = f
+	return f
+}
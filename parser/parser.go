@@ -1,117 +1,344 @@
 package parser
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/xml"
-	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"io"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/erikfastermann/feeder/db"
+	"golang.org/x/net/html"
 )
 
+type link struct {
+	Text string `xml:",chardata"`
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+}
+
+// alternate returns the RSS link or the Atom link to the website.
+func alternate(links []link) string {
+	for _, l := range links {
+		if s := strings.TrimSpace(l.Text); s != "" {
+			return s
+		}
+	}
+	for _, l := range links {
+		if l.Href != "" && (l.Rel == "" || l.Rel == "alternate") {
+			return strings.TrimSpace(l.Href)
+		}
+	}
+	return ""
+}
+
+// hub returns the WebSub hub and the self link of the feed.
+func hub(links []link) (hub, self string) {
+	for _, l := range links {
+		for _, rel := range strings.Fields(l.Rel) {
+			switch {
+			case rel == "hub" && hub == "":
+				hub = strings.TrimSpace(l.Href)
+			case rel == "self" && self == "":
+				self = strings.TrimSpace(l.Href)
+			}
+		}
+	}
+	return hub, self
+}
+
+// category is an RSS category or the term of an Atom category.
+type category struct {
+	Term string `xml:"term,attr"`
+	Text string `xml:",chardata"`
+}
+
+// channel holds the elements of an RSS channel or Atom feed that
+// describe the feed itself.
+type channel struct {
+	Title       string `xml:"title"`
+	Subtitle    string `xml:"subtitle"`
+	Description string `xml:"description"`
+	Links       []link `xml:"link"`
+	Language    string `xml:"language"`
+	Lang        string `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+	Icon        string `xml:"icon"`
+	Logo        string `xml:"logo"`
+	Image       struct {
+		URL string `xml:"url"`
+	} `xml:"image"`
+	Generator string `xml:"generator"`
+}
+
+// first returns the first of s that is not blank, trimmed.
+func first(s ...string) string {
+	for _, s := range s {
+		if s = strings.TrimSpace(s); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+func (c channel) meta() db.FeedMeta {
+	return db.FeedMeta{
+		Title:       first(c.Title),
+		Description: first(c.Subtitle, c.Description),
+		SiteURL:     alternate(c.Links),
+		Language:    first(c.Language, c.Lang),
+		Icon:        first(c.Icon, c.Image.URL, c.Logo),
+		Generator:   first(c.Generator),
+	}
+}
+
 type item struct {
+	GUID      string `xml:"guid"`
+	ID        string `xml:"id"`
 	Title     string `xml:"title"`
 	Updated   string `xml:"updated"`
 	PubDate   string `xml:"pubDate"`
 	Published string `xml:"published"`
-	Link      struct {
+	Links     []link `xml:"link"`
+	Author    struct {
+		Name string `xml:"name"`
 		Text string `xml:",chardata"`
-		Href string `xml:"href,attr"`
-	} `xml:"link"`
+	} `xml:"author"`
+	Creator    string     `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Categories []category `xml:"category"`
+
+	Description string `xml:"description"`
+	Encoded     string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	Summary     string `xml:"summary"`
+	Content     string `xml:"content"`
 }
 
-func Parse(url string) ([]db.Item, error) {
-	c := &http.Client{Timeout: 10 * time.Second}
-	res, err := c.Get(url)
+// entry returns what the rules can match beyond the stored item.
+func (i item) entry() Entry {
+	e := Entry{
+		Content:    text(first(i.Content, i.Encoded, i.Description, i.Summary)),
+		Author:     first(i.Author.Name, i.Author.Text, i.Creator),
+		Categories: make([]string, 0),
+	}
+	for _, c := range i.Categories {
+		if s := first(c.Term, c.Text); s != "" {
+			e.Categories = append(e.Categories, s)
+		}
+	}
+	return e
+}
+
+// text returns the text of an HTML fragment without the markup.
+func text(s string) string {
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return strings.Join(strings.Fields(b.String()), " ")
+		case html.TextToken:
+			b.Write(z.Text())
+			b.WriteByte(' ')
+		}
+	}
+}
+
+// digest changes if the title, content or update time of the entry change.
+func (i item) digest() string {
+	h := sha256.New()
+	for _, s := range []string{i.Title, i.Description, i.Encoded, i.Summary, i.Content, i.Updated} {
+		io.WriteString(h, strings.TrimSpace(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// Feed is a parsed feed.
+type Feed struct {
+	db.FeedMeta
+	Items []db.Item
+	// Entries[i] holds more about Items[i], it is not stored.
+	Entries []Entry
+	// Skipped is the number of items that could not be used.
+	Skipped int
+	// Warnings describe the skipped items and the ones that were repaired.
+	Warnings []error
+	// PermanentURL is the new URL of the feed
+	// if the server redirected permanently.
+	PermanentURL string
+	// Hub is the WebSub hub the feed advertises and Self the URL
+	// it is published under, see package websub.
+	Hub, Self string
+}
+
+// Entry is what is known about an item beyond the stored fields.
+type Entry struct {
+	// Content is the text of the content or summary without markup.
+	Content    string
+	Author     string
+	Categories []string
+}
+
+// Parse fetches and parses the feed at url.
+func (p *Parser) Parse(ctx context.Context, url string) (*Feed, error) {
+	return p.ParseWith(ctx, url, Options{})
+}
+
+// ParseWith is Parse with options for the feed.
+func (p *Parser) ParseWith(ctx context.Context, url string, opts Options) (*Feed, error) {
+	res, err := p.fetch(ctx, url, opts)
 	if err != nil {
 		return nil, err
 	}
+	return p.parseResponse(url, res)
+}
 
-	data, err := ioutil.ReadAll(res.Body)
-	res.Body.Close()
+func (p *Parser) parseResponse(url string, res *response) (*Feed, error) {
+	feed, err := parseFeed(toUTF8(res.data, res.contentType))
 	if err != nil {
+		if isHTML(res.contentType) {
+			return nil, &ContentTypeError{URL: url, ContentType: res.contentType}
+		}
 		return nil, err
 	}
+	feed.PermanentURL = res.permanentURL
+	// The Link header takes precedence over the links in the feed.
+	if res.hub != "" {
+		feed.Hub, feed.Self = res.hub, res.self
+	}
+	return feed, nil
+}
+
+// ParseBody parses a feed that was received instead of fetched, like
+// the content pushed by a WebSub hub. The url is only used in errors.
+func (p *Parser) ParseBody(url string, data []byte, contentType string) (*Feed, error) {
+	return p.parseResponse(url, &response{data: data, contentType: contentType})
+}
+
+var timeNow = time.Now
 
-	items, err := parse(data)
+// parseFeed parses a feed that was transcoded to UTF-8 by toUTF8.
+func parseFeed(data []byte) (*Feed, error) {
+	ch, items, err := parse(data)
 	if err != nil {
 		return nil, err
 	}
 
-	finals := make([]db.Item, 0)
-	for _, i := range items {
+	now := timeNow()
+	feed := &Feed{FeedMeta: ch.meta(), Items: make([]db.Item, 0), Entries: make([]Entry, 0)}
+	feed.Hub, feed.Self = hub(ch.Links)
+	warnf := func(n int, i item, format string, a ...interface{}) {
+		name := strconv.Quote(strings.TrimSpace(i.Title))
+		if name == `""` {
+			name = "#" + strconv.Itoa(n+1)
+		}
+		feed.Warnings = append(feed.Warnings, fmt.Errorf("item %s: %s", name, fmt.Sprintf(format, a...)))
+	}
+	for n, i := range items {
 		final := db.Item{
 			FeedID: -1,
 			Title:  i.Title,
+			Digest: i.digest(),
 		}
-
 		switch {
-		case i.Link.Text != "":
-			final.URL = i.Link.Text
-		case i.Link.Href != "":
-			final.URL = i.Link.Href
-		default:
-			return nil, errors.New("post without a link")
+		case i.GUID != "":
+			final.GUID = strings.TrimSpace(i.GUID)
+		case i.ID != "":
+			final.GUID = strings.TrimSpace(i.ID)
 		}
 
-		dateStr := ""
-		switch {
-		case i.Updated != "":
-			dateStr = i.Updated
-		case i.PubDate != "":
-			dateStr = i.PubDate
-		case i.Published != "":
-			dateStr = i.Published
-		default:
-			final.Added = time.Now()
+		final.URL = alternate(i.Links)
+		if final.URL == "" && isURL(final.GUID) {
+			final.URL = final.GUID
+			warnf(n, i, "no link, using the guid")
 		}
-		if dateStr != "" {
-			final.Added, err = parseDate(dateStr)
+		if final.URL == "" {
+			feed.Skipped++
+			warnf(n, i, "no link, skipped")
+			continue
+		}
+
+		// Items without a usable date are dated when they are first seen,
+		// stored items keep the time they were added with.
+		final.Added = now
+		var dateErr error
+		for _, dateStr := range []string{i.Updated, i.PubDate, i.Published} {
+			t, err := parseDate(dateStr)
 			if err != nil {
-				return nil, err
+				if dateStr != "" && dateErr == nil {
+					dateErr = err
+				}
+				continue
 			}
+			if t.After(now) {
+				t = now
+			}
+			final.Added = t
+			dateErr = nil
+			break
+		}
+		if dateErr != nil {
+			warnf(n, i, "%v, using the time it was first seen", dateErr)
 		}
 
-		finals = append(finals, final)
+		feed.Items = append(feed.Items, final)
+		feed.Entries = append(feed.Entries, i.entry())
 	}
-	return finals, nil
+	return feed, nil
+}
+
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
 }
 
-func parse(data []byte) ([]item, error) {
+func parse(data []byte) (channel, []item, error) {
 	feed := struct {
 		XMLName xml.Name `xml:"feed"`
-		Entries []item   `xml:"entry"`
+		channel
+		Entries []item `xml:"entry"`
 	}{}
-	if err := xml.Unmarshal(data, &feed); err != nil {
+	if err := unmarshal(data, &feed); err != nil {
 		rss := struct {
 			XMLName xml.Name `xml:"rss"`
 			Channel struct {
+				channel
 				Items []item `xml:"item"`
 			} `xml:"channel"`
 		}{}
-		if err := xml.Unmarshal(data, &rss); err != nil {
-			return nil, err
+		if err := unmarshal(data, &rss); err != nil {
+			if format := unsupportedFormat(data); format != "" {
+				return channel{}, nil, &UnsupportedFormatError{Format: format}
+			}
+			return channel{}, nil, err
 		}
-		return rss.Channel.Items, nil
+		return rss.Channel.channel, rss.Channel.Items, nil
 	}
-	return feed.Entries, nil
+	return feed.channel, feed.Entries, nil
 }
 
-func parseDate(str string) (time.Time, error) {
-	for _, layout := range []string{
-		time.RFC1123,     // Mon, 02 Jan 2006 15:04:05 MST
-		time.RFC1123Z,    // Mon, 02 Jan 2006 15:04:05 -0700
-		time.RFC3339,     // 2006-01-02T15:04:05Z07:00
-		time.RFC3339Nano, // 2006-01-02T15:04:05.999999999Z07:00
-		time.UnixDate,    // Mon Jan 2 15:04:05 MST 2006
-	} {
-		t, err := time.Parse(layout, str)
-		if err != nil {
-			continue
-		}
-		return t, nil
+// UnsupportedFormatError is returned for feeds in a format
+// the parser does not read, only Atom and RSS 0.9x/2.0 are.
+type UnsupportedFormatError struct {
+	Format string
+}
+
+func (e *UnsupportedFormatError) Error() string {
+	return fmt.Sprintf("parser: unsupported feed format %s", e.Format)
+}
+
+// unsupportedFormat returns the format of a feed that is not Atom or RSS,
+// or an empty string if data is not a known feed format.
+func unsupportedFormat(data []byte) string {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return "JSON Feed"
+	}
+	var root struct{ XMLName xml.Name }
+	if unmarshal(data, &root) == nil && root.XMLName.Local == "RDF" {
+		return "RSS 1.0 (RDF)"
 	}
-	return time.Time{}, fmt.Errorf("time %s is invalid", str)
+	return ""
 }
@@ -0,0 +1,48 @@
+package parser
+
+import "testing"
+
+func TestParseSkipsItems(t *testing.T) {
+	feed, err := parseFeed([]byte(`<rss><channel>
+<item><title>no link</title></item>
+<item><title>guid</title><guid>https://example.com/guid</guid></item>
+<item><title>bad date</title><link>https://example.com/date</link><pubDate>soon</pubDate></item>
+<item><link>https://example.com/ok</link></item>
+<item><guid>urn:1</guid></item>
+</channel></rss>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var urls []string
+	for _, item := range feed.Items {
+		urls = append(urls, item.URL)
+	}
+	want := []string{"https://example.com/guid", "https://example.com/date", "https://example.com/ok"}
+	if len(urls) != len(want) {
+		t.Fatalf("got items %v, want %v", urls, want)
+	}
+	for i := range want {
+		if urls[i] != want[i] {
+			t.Fatalf("got items %v, want %v", urls, want)
+		}
+	}
+
+	if feed.Skipped != 2 {
+		t.Errorf("got %d skipped items, want 2", feed.Skipped)
+	}
+	wantWarnings := []string{
+		`item "no link": no link, skipped`,
+		`item "guid": no link, using the guid`,
+		`item "bad date": time "soon" is invalid, using the time it was first seen`,
+		`item #5: no link, skipped`,
+	}
+	if len(feed.Warnings) != len(wantWarnings) {
+		t.Fatalf("got warnings %v, want %v", feed.Warnings, wantWarnings)
+	}
+	for i, w := range feed.Warnings {
+		if w.Error() != wantWarnings[i] {
+			t.Errorf("got warning %q, want %q", w, wantWarnings[i])
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+)
+
+// Policy restricts where feeds are fetched from, so the URLs that users
+// add can't be used to reach the network feeder runs in. Only http and
+// https are allowed, and all addresses that are not globally reachable
+// are blocked unless they are in Allow. Clients created by NewClient
+// check the addresses on every dial, which includes every redirect.
+type Policy struct {
+	Allow []*net.IPNet
+}
+
+// BlockedError is returned for URLs the Policy does not allow.
+type BlockedError struct {
+	URL    string
+	Reason string
+}
+
+func (e *BlockedError) Error() string {
+	return fmt.Sprintf("parser: %s is not allowed, %s", e.URL, e.Reason)
+}
+
+// blocked are the ranges that are not globally reachable
+// and not covered by the methods of net.IP.
+var blocked = func() []*net.IPNet {
+	nets := make([]*net.IPNet, 0)
+	for _, cidr := range []string{
+		"0.0.0.0/8",
+		"100.64.0.0/10",
+		"192.0.0.0/24",
+		"192.0.2.0/24",
+		"198.18.0.0/15",
+		"198.51.100.0/24",
+		"203.0.113.0/24",
+		"240.0.0.0/4",
+		"64:ff9b::/96",
+		"100::/64",
+		"2001:db8::/32",
+	} {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// ParseAllowList parses a comma separated list of networks in CIDR
+// notation or single addresses.
+func ParseAllowList(s string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0)
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !strings.Contains(field, "/") {
+			ip := net.ParseIP(field)
+			if ip == nil {
+				return nil, fmt.Errorf("parser: invalid address %s", field)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(field)
+		if err != nil {
+			return nil, fmt.Errorf("parser: invalid network %s, %v", field, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// allowed returns why ip is blocked, or an empty string.
+func (p *Policy) allowed(ip net.IP) string {
+	for _, n := range p.Allow {
+		if n.Contains(ip) {
+			return ""
+		}
+	}
+	switch {
+	case ip.IsLoopback():
+		return "loopback address " + ip.String()
+	case ip.IsPrivate():
+		return "private address " + ip.String()
+	case ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast():
+		return "link-local address " + ip.String()
+	case ip.IsUnspecified() || ip.IsMulticast():
+		return "address " + ip.String()
+	}
+	for _, n := range blocked {
+		if n.Contains(ip) {
+			return "reserved address " + ip.String()
+		}
+	}
+	return ""
+}
+
+func checkScheme(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return &BlockedError{URL: u.String(), Reason: "only http and https are supported"}
+	}
+	if u.Hostname() == "" {
+		return &BlockedError{URL: u.String(), Reason: "missing host"}
+	}
+	return nil
+}
+
+// Check resolves the host of rawURL and returns an error
+// if any of its addresses is not allowed.
+func (p *Policy) Check(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if err := checkScheme(u); err != nil {
+		return err
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if reason := p.allowed(addr.IP); reason != "" {
+			return &BlockedError{URL: rawURL, Reason: reason}
+		}
+	}
+	return nil
+}
+
+// control is called by the dialer with the resolved address
+// before a connection is made.
+func (p *Policy) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("parser: unresolved address %s", address)
+	}
+	if reason := p.allowed(ip); reason != "" {
+		return &BlockedError{URL: network + "://" + address, Reason: reason}
+	}
+	return nil
+}
+
+// policyTransport checks every request before it is sent. It is used
+// with proxies, where the addresses that are dialed are the proxy's.
+type policyTransport struct {
+	policy *Policy
+	base   http.RoundTripper
+}
+
+func (t policyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.policy.Check(req.Context(), req.URL.String()); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,96 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	p := &Policy{}
+	for _, ip := range []string{
+		"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254",
+		"100.64.0.1", "0.0.0.0", "::1", "fe80::1", "fd00::1", "::ffff:127.0.0.1", "224.0.0.1",
+	} {
+		if p.allowed(net.ParseIP(ip)) == "" {
+			t.Errorf("%s is allowed", ip)
+		}
+	}
+	for _, ip := range []string{"93.184.216.34", "2606:2800:220:1:248:1893:25c8:1946"} {
+		if reason := p.allowed(net.ParseIP(ip)); reason != "" {
+			t.Errorf("%s is blocked, %s", ip, reason)
+		}
+	}
+
+	allow, err := ParseAllowList("10.0.0.0/8,192.168.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Allow = allow
+	for _, ip := range []string{"10.1.2.3", "192.168.1.1"} {
+		if reason := p.allowed(net.ParseIP(ip)); reason != "" {
+			t.Errorf("%s is blocked, %s", ip, reason)
+		}
+	}
+	if p.allowed(net.ParseIP("192.168.1.2")) == "" {
+		t.Error("192.168.1.2 is allowed")
+	}
+	if _, err := ParseAllowList("10.0.0.0/33"); err == nil {
+		t.Error("invalid network accepted")
+	}
+
+	ctx := context.Background()
+	for _, u := range []string{"file:///etc/passwd", "gopher://example.com/", "http://127.0.0.1/", "http:///feed"} {
+		if _, ok := (&Policy{}).Check(ctx, u).(*BlockedError); !ok {
+			t.Errorf("%s is not blocked", u)
+		}
+	}
+}
+
+func TestPolicyClient(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/feed", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testFeed))
+	})
+	mux.Handle("/redirect", http.RedirectHandler("http://169.254.169.254/latest/meta-data/", http.StatusFound))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx := context.Background()
+	blocking := &Parser{Fetcher: NewClient(HTTPConfig{Policy: &Policy{}})}
+	var blocked *BlockedError
+	if _, err := blocking.Parse(ctx, srv.URL+"/feed"); !errors.As(err, &blocked) {
+		t.Fatalf("got %v, want a BlockedError", err)
+	}
+	if _, err := blocking.Parse(ctx, "file:///etc/passwd"); !errors.As(err, &blocked) {
+		t.Fatalf("got %v, want a BlockedError", err)
+	}
+
+	// The test server listens on the loopback interface.
+	allow, err := ParseAllowList("127.0.0.0/8, ::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Parser{Fetcher: NewClient(HTTPConfig{Policy: &Policy{Allow: allow}})}
+	if _, err := p.Parse(ctx, srv.URL+"/feed"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Parse(ctx, srv.URL+"/redirect"); !errors.As(err, &blocked) {
+		t.Fatalf("got %v, want a BlockedError", err)
+	}
+
+	// The test server is a proxy on the loopback interface as well,
+	// the feed is requested from a public address through it.
+	const public = "http://93.184.216.34/feed"
+	blocking = &Parser{Config: HTTPConfig{Policy: &Policy{}}}
+	if _, err := blocking.ParseWith(ctx, public, Options{Proxy: srv.URL}); !errors.As(err, &blocked) {
+		t.Fatalf("got %v, want a BlockedError for the proxy", err)
+	}
+	p = &Parser{Config: HTTPConfig{Policy: &Policy{Allow: allow}}}
+	if _, err := p.ParseWith(ctx, public, Options{Proxy: srv.URL}); err != nil {
+		t.Fatal(err)
+	}
+}
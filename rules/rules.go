@@ -0,0 +1,214 @@
+// Package rules filters and marks incoming items with the rules
+// stored in the db, before the items are added.
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/erikfastermann/feeder/db"
+	"github.com/erikfastermann/feeder/parser"
+)
+
+// Fields an item is matched on.
+const (
+	FieldTitle    = "title"
+	FieldContent  = "content"
+	FieldAuthor   = "author"
+	FieldCategory = "category"
+	// FieldAny matches any of the other fields.
+	FieldAny = "any"
+)
+
+// Ways a pattern is matched, substrings and globs ignore case.
+const (
+	MatchSubstring = "substring"
+	MatchRegexp    = "regexp"
+	MatchGlob      = "glob"
+)
+
+// Actions of a rule.
+const (
+	ActionDrop = "drop"
+	ActionRead = "read"
+	ActionStar = "star"
+	ActionTag  = "tag"
+)
+
+var (
+	Fields  = []string{FieldTitle, FieldContent, FieldAuthor, FieldCategory, FieldAny}
+	Matches = []string{MatchSubstring, MatchRegexp, MatchGlob}
+	Actions = []string{ActionDrop, ActionRead, ActionStar, ActionTag}
+)
+
+func oneOf(s string, valid []string) bool {
+	for _, v := range valid {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Rule is a compiled db.Rule.
+type Rule struct {
+	db.Rule
+	re *regexp.Regexp
+}
+
+// Compile validates r and compiles its pattern.
+// An empty pattern matches every item.
+func Compile(r db.Rule) (*Rule, error) {
+	if !oneOf(r.Field, Fields) {
+		return nil, fmt.Errorf("rules: unknown field %q", r.Field)
+	}
+	if !oneOf(r.Action, Actions) {
+		return nil, fmt.Errorf("rules: unknown action %q", r.Action)
+	}
+	if r.OlderThan < 0 {
+		return nil, errors.New("rules: negative age")
+	}
+	if r.Action == ActionTag {
+		if r.Tag == "" || r.Tag != strings.TrimSpace(r.Tag) || strings.Contains(r.Tag, ",") {
+			return nil, fmt.Errorf("rules: invalid tag %q, tags can't be empty or contain commas", r.Tag)
+		}
+	} else if r.Tag != "" {
+		return nil, fmt.Errorf("rules: tag %q without the tag action", r.Tag)
+	}
+
+	var expr string
+	switch r.Match {
+	case MatchSubstring:
+		expr = "(?i)" + regexp.QuoteMeta(r.Pattern)
+	case MatchRegexp:
+		expr = r.Pattern
+	case MatchGlob:
+		expr = "(?is)^" + globToRegexp(r.Pattern) + "$"
+	default:
+		return nil, fmt.Errorf("rules: unknown match %q", r.Match)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("rules: invalid pattern, %v", err)
+	}
+	return &Rule{Rule: r, re: re}, nil
+}
+
+// globToRegexp translates * and ? to a regular expression,
+// everything else is matched literally.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return b.String()
+}
+
+// Entry is an item as the rules see it.
+type Entry struct {
+	Item db.Item
+	parser.Entry
+}
+
+// Match reports whether the rule applies to e at now.
+// It does not check the feed of the rule.
+func (r *Rule) Match(e Entry, now time.Time) bool {
+	if r.OlderThan > 0 && e.Item.Added.After(now.Add(-r.OlderThan)) {
+		return false
+	}
+	if r.Pattern == "" {
+		return true
+	}
+
+	values := make([]string, 0)
+	if r.Field == FieldTitle || r.Field == FieldAny {
+		values = append(values, e.Item.Title)
+	}
+	if r.Field == FieldContent || r.Field == FieldAny {
+		values = append(values, e.Content)
+	}
+	if r.Field == FieldAuthor || r.Field == FieldAny {
+		values = append(values, e.Author)
+	}
+	if r.Field == FieldCategory || r.Field == FieldAny {
+		values = append(values, e.Categories...)
+	}
+	for _, v := range values {
+		if r.re.MatchString(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Set holds the compiled rules of all feeds.
+type Set []*Rule
+
+// Load compiles the rules stored in d. Rules that don't compile
+// anymore are left out and returned as errors.
+func Load(d *db.DB) (Set, []error, error) {
+	stored, err := d.Rules()
+	if err != nil {
+		return nil, nil, err
+	}
+	set := make(Set, 0, len(stored))
+	invalid := make([]error, 0)
+	for _, r := range stored {
+		compiled, err := Compile(r)
+		if err != nil {
+			invalid = append(invalid, fmt.Errorf("rule %d: %v", r.ID, err))
+			continue
+		}
+		set = append(set, compiled)
+	}
+	return set, invalid, nil
+}
+
+// Apply runs the global rules and the ones of the feed on its items.
+// It returns the items that were not dropped, marked read or starred
+// and tagged by the matching rules, and the number of dropped items.
+func (s Set) Apply(feedID int, feed *parser.Feed, now time.Time) ([]db.Item, int) {
+	items := make([]db.Item, 0, len(feed.Items))
+	dropped := 0
+	for i, item := range feed.Items {
+		e := Entry{Item: item}
+		if i < len(feed.Entries) {
+			e.Entry = feed.Entries[i]
+		}
+
+		drop := false
+		for _, r := range s {
+			if (r.FeedID != 0 && r.FeedID != feedID) || !r.Match(e, now) {
+				continue
+			}
+			switch r.Action {
+			case ActionDrop:
+				drop = true
+			case ActionRead:
+				item.Read = true
+			case ActionStar:
+				item.Starred = true
+			case ActionTag:
+				if !oneOf(r.Tag, item.Tags) {
+					item.Tags = append(item.Tags, r.Tag)
+				}
+			}
+		}
+		if drop {
+			dropped++
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, dropped
+}
@@ -0,0 +1,112 @@
+package rules
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/erikfastermann/feeder/db"
+	"github.com/erikfastermann/feeder/parser"
+)
+
+func TestCompile(t *testing.T) {
+	for _, r := range []db.Rule{
+		{Field: "nope", Match: MatchSubstring, Action: ActionDrop},
+		{Field: FieldTitle, Match: "nope", Action: ActionDrop},
+		{Field: FieldTitle, Match: MatchSubstring, Action: "nope"},
+		{Field: FieldTitle, Match: MatchRegexp, Pattern: "(", Action: ActionDrop},
+		{Field: FieldTitle, Match: MatchSubstring, Action: ActionTag},
+		{Field: FieldTitle, Match: MatchSubstring, Action: ActionTag, Tag: "a,b"},
+		{Field: FieldTitle, Match: MatchSubstring, Action: ActionRead, Tag: "a"},
+		{Field: FieldTitle, Match: MatchSubstring, Action: ActionRead, OlderThan: -time.Hour},
+	} {
+		if _, err := Compile(r); err == nil {
+			t.Errorf("compiled invalid rule %+v", r)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	e := Entry{
+		Item: db.Item{Title: "Sponsored: Buy a Gopher", Added: now.Add(-72 * time.Hour)},
+		Entry: parser.Entry{
+			Content:    "Gophers are great.",
+			Author:     "Jane Doe",
+			Categories: []string{"Ads", "Go/News"},
+		},
+	}
+	for _, tc := range []struct {
+		rule db.Rule
+		want bool
+	}{
+		{db.Rule{Field: FieldTitle, Match: MatchSubstring, Pattern: "sponsored"}, true},
+		{db.Rule{Field: FieldContent, Match: MatchSubstring, Pattern: "sponsored"}, false},
+		{db.Rule{Field: FieldAny, Match: MatchSubstring, Pattern: "GREAT"}, true},
+		{db.Rule{Field: FieldAuthor, Match: MatchRegexp, Pattern: "^Jane"}, true},
+		{db.Rule{Field: FieldAuthor, Match: MatchRegexp, Pattern: "^jane"}, false},
+		{db.Rule{Field: FieldCategory, Match: MatchGlob, Pattern: "go/*"}, true},
+		{db.Rule{Field: FieldCategory, Match: MatchGlob, Pattern: "go"}, false},
+		{db.Rule{Field: FieldTitle, Match: MatchGlob, Pattern: "sponsored:*"}, true},
+		{db.Rule{Field: FieldTitle, Match: MatchGlob, Pattern: "Sponsored.*"}, false},
+		{db.Rule{Field: FieldTitle, Match: MatchSubstring, OlderThan: 48 * time.Hour}, true},
+		{db.Rule{Field: FieldTitle, Match: MatchSubstring, Pattern: "gopher", OlderThan: 96 * time.Hour}, false},
+	} {
+		tc.rule.Action = ActionDrop
+		r, err := Compile(tc.rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := r.Match(e, now); got != tc.want {
+			t.Errorf("%+v: got %v, want %v", tc.rule, got, tc.want)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	now := time.Now()
+	feed := &parser.Feed{
+		Items: []db.Item{
+			{Title: "ad", URL: "1", Added: now},
+			{Title: "go 1.15 released", URL: "2", Added: now},
+			{Title: "old news", URL: "3", Added: now.Add(-30 * 24 * time.Hour)},
+		},
+		Entries: []parser.Entry{
+			{Categories: []string{"ads"}},
+			{Categories: []string{"go"}},
+			{},
+		},
+	}
+	var set Set
+	for _, r := range []db.Rule{
+		{Field: FieldCategory, Match: MatchSubstring, Pattern: "ads", Action: ActionDrop},
+		{FeedID: 1, Field: FieldAny, Match: MatchRegexp, Pattern: `\bgo\b`, Action: ActionStar},
+		{FeedID: 1, Field: FieldAny, Match: MatchRegexp, Pattern: `\bgo\b`, Action: ActionTag, Tag: "go"},
+		{FeedID: 2, Field: FieldTitle, Match: MatchSubstring, Action: ActionDrop},
+		{Field: FieldTitle, Match: MatchSubstring, OlderThan: 7 * 24 * time.Hour, Action: ActionRead},
+	} {
+		compiled, err := Compile(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		set = append(set, compiled)
+	}
+
+	items, dropped := set.Apply(1, feed, now)
+	if dropped != 1 || len(items) != 2 {
+		t.Fatalf("got %d items and %d dropped, want 2 and 1", len(items), dropped)
+	}
+	if !items[0].Starred || items[0].Read || !reflect.DeepEqual(items[0].Tags, []string{"go"}) {
+		t.Errorf("unexpected item %+v", items[0])
+	}
+	if items[1].Starred || !items[1].Read || items[1].Tags != nil {
+		t.Errorf("unexpected item %+v", items[1])
+	}
+	if feed.Items[1].Starred || feed.Items[1].Tags != nil {
+		t.Error("Apply changed the items of the feed")
+	}
+
+	if items, dropped := set.Apply(2, feed, now); dropped != 3 || len(items) != 0 {
+		t.Errorf("got %d items and %d dropped, want 0 and 3", len(items), dropped)
+	}
+}
@@ -0,0 +1,83 @@
+// Package secret encrypts the values feeder stores on disk
+// but must not be readable from the data directory alone.
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// MinKeyLen is the minimum length of a key passed to New.
+const MinKeyLen = 16
+
+var ErrInvalid = errors.New("secret: invalid or tampered value")
+
+// Box seals values with AES-256-GCM.
+type Box struct {
+	aead cipher.AEAD
+}
+
+// New returns a Box for key, which should be a long random string.
+// The AES key is its SHA-256 hash.
+func New(key string) (*Box, error) {
+	if len(key) < MinKeyLen {
+		return nil, errors.New("secret: key is too short")
+	}
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{aead: aead}, nil
+}
+
+// Seal encrypts plain and returns it in base64 with a random nonce.
+func (b *Box) Seal(plain []byte) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return base64.RawStdEncoding.EncodeToString(b.aead.Seal(nonce, nonce, plain, nil)), nil
+}
+
+// Open decrypts a value returned by Seal.
+func (b *Box) Open(sealed string) ([]byte, error) {
+	data, err := base64.RawStdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < b.aead.NonceSize() {
+		return nil, ErrInvalid
+	}
+	n := b.aead.NonceSize()
+	plain, err := b.aead.Open(nil, data[:n], data[n:], nil)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	return plain, nil
+}
+
+// SealJSON encodes v as JSON and seals it.
+func (b *Box) SealJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return b.Seal(data)
+}
+
+// OpenJSON opens sealed and decodes the JSON into v.
+func (b *Box) OpenJSON(sealed string, v interface{}) error {
+	data, err := b.Open(sealed)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,51 @@
+package secret
+
+import "testing"
+
+func TestBox(t *testing.T) {
+	if _, err := New("short"); err == nil {
+		t.Fatal("short key accepted")
+	}
+	b, err := New("0123456789abcdef-some-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type value struct{ User, Password string }
+	in := value{"user", "hunter2"}
+	sealed, err := b.SealJSON(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed2, err := b.SealJSON(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sealed == sealed2 {
+		t.Fatal("sealing twice gave the same value")
+	}
+
+	var out value
+	if err := b.OpenJSON(sealed, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out != in {
+		t.Fatalf("got %+v, want %+v", out, in)
+	}
+
+	other, err := New("0123456789abcdef-other-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Open(sealed); err != ErrInvalid {
+		t.Fatalf("opened with another key, %v", err)
+	}
+	tampered := []byte(sealed)
+	tampered[len(tampered)/2] ^= 1
+	if _, err := b.Open(string(tampered)); err != ErrInvalid {
+		t.Fatalf("opened a tampered value, %v", err)
+	}
+	if _, err := b.Open("!"); err != ErrInvalid {
+		t.Fatalf("opened garbage, %v", err)
+	}
+}
@@ -0,0 +1,14 @@
+// Shows how many items were added since the page was loaded.
+(function() {
+	const banner = document.getElementById("live");
+	if (banner == null || typeof EventSource == "undefined") {
+		return;
+	};
+	let count = 0;
+	const source = new EventSource("/events");
+	source.addEventListener("items", function(e) {
+		count += JSON.parse(e.data).items.length;
+		banner.firstElementChild.textContent = count + (count == 1 ? " new item" : " new items");
+		banner.hidden = false;
+	});
+})();
@@ -0,0 +1,6 @@
+function edit(id, name) {
+	const newName = prompt('Set new name for: "' + name + '"');
+	if (!(newName == null || newName == "")) {
+		window.location.href = "/edit?id=" + id + "&name=" + encodeURIComponent(newName);
+	};
+}
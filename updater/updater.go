@@ -0,0 +1,521 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/erikfastermann/feeder/db"
+	"github.com/erikfastermann/feeder/icon"
+	"github.com/erikfastermann/feeder/internal/worker"
+	"github.com/erikfastermann/feeder/notify"
+	"github.com/erikfastermann/feeder/parser"
+	"github.com/erikfastermann/feeder/rules"
+	"github.com/erikfastermann/feeder/secret"
+	"github.com/erikfastermann/feeder/websub"
+)
+
+const DefaultInterval = time.Hour
+
+// listenBuffer is the number of results a listener may fall behind.
+const listenBuffer = 64
+
+// pushedInterval is how often feeds with an active WebSub subscription
+// are polled anyway, in case the hub stopped pushing.
+const pushedInterval = 24 * time.Hour
+
+var ErrStopped = errors.New("updater: stopped")
+
+// Updater fetches all feeds in the background and stores new items.
+// Only one pass runs at a time, requests for a refresh while a pass
+// is running are merged into it or into the next pass.
+type Updater struct {
+	Logger *log.Logger
+	DB     *db.DB
+	Parser *parser.Parser
+	// Secrets opens the fetch options of the feeds.
+	Secrets  *secret.Box
+	Interval time.Duration
+	// Retention is enforced after every scheduled pass.
+	Retention db.Retention
+	// Icons, if set, are looked up after every scheduled pass
+	// for the feeds without a recent icon.
+	Icons *icon.Cache
+	// Notifier, if set, is passed the new items after every pass.
+	// Items added to a feed for the first time are not passed.
+	Notifier *notify.Notifier
+	// WebSub, if set, is asked to subscribe to the hubs the feeds
+	// advertise. Scheduled passes skip the feeds the hubs push.
+	WebSub *websub.Subscriber
+
+	initOnce sync.Once
+	workers  worker.Group
+
+	mu        sync.Mutex
+	stopped   bool
+	running   *run
+	pending   *run
+	listeners map[chan Result]struct{}
+}
+
+// Result is the outcome of fetching a single feed.
+type Result struct {
+	Feed     db.Feed
+	NewItems int
+	// Items are the new items.
+	Items []db.Item
+	Err   error
+}
+
+type run struct {
+	all bool
+	// ids are fetched even if they are disabled or pushed.
+	ids map[int]bool
+	// todo are the ids the pass fetches, nil until it started.
+	todo    map[int]bool
+	done    chan struct{}
+	total   int
+	results []Result
+}
+
+func newRun(ids []int) *run {
+	r := &run{ids: make(map[int]bool), done: make(chan struct{})}
+	r.merge(ids)
+	return r
+}
+
+// covers reports whether the run fetches all of the ids,
+// or all feeds if none are given.
+func (r *run) covers(ids []int) bool {
+	if len(ids) == 0 {
+		return r.all
+	}
+	for _, id := range ids {
+		if r.todo == nil && !r.ids[id] || r.todo != nil && !r.todo[id] {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *run) merge(ids []int) {
+	if len(ids) == 0 {
+		r.all = true
+	}
+	for _, id := range ids {
+		r.ids[id] = true
+	}
+}
+
+func (u *Updater) init() {
+	u.initOnce.Do(func() {
+		if u.Logger == nil {
+			u.Logger = log.New(ioutil.Discard, "", 0)
+		}
+		if u.Parser == nil {
+			u.Parser = &parser.Parser{}
+		}
+		if u.Interval <= 0 {
+			u.Interval = DefaultInterval
+		}
+	})
+}
+
+// Start updates all feeds immediately and then every Interval
+// until Shutdown is called.
+func (u *Updater) Start() {
+	u.init()
+	u.workers.Go(u.loop)
+}
+
+func (u *Updater) loop() {
+	t := time.NewTicker(u.Interval)
+	defer t.Stop()
+	for {
+		if r, err := u.schedule(nil); err == nil {
+			<-r.done
+			u.compact()
+			u.pruneSubscriptions()
+			u.updateIcons()
+		}
+		select {
+		case <-u.workers.Stopping():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Refresh fetches the feeds with the given ids, or all feeds if none are
+// given, and waits until they are stored. Unknown ids are ignored.
+// If the running pass already includes the feeds, Refresh waits for it
+// instead of fetching them again. Otherwise they are fetched
+// in the next pass.
+func (u *Updater) Refresh(ctx context.Context, ids ...int) ([]Result, error) {
+	u.init()
+	r, err := u.schedule(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if len(ids) == 0 {
+		return r.results, nil
+	}
+	want := make(map[int]bool)
+	for _, id := range ids {
+		want[id] = true
+	}
+	results := make([]Result, 0)
+	for _, res := range r.results {
+		if want[res.Feed.ID] {
+			results = append(results, res)
+		}
+	}
+	return results, nil
+}
+
+// Progress reports whether a pass is running
+// and how many of its feeds are already done.
+func (u *Updater) Progress() (running bool, done, total int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.running == nil {
+		return false, 0, 0
+	}
+	return true, len(u.running.results), u.running.total
+}
+
+func (u *Updater) schedule(ids []int) (*run, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.stopped {
+		return nil, ErrStopped
+	}
+	if u.running == nil {
+		r := newRun(ids)
+		u.running = r
+		u.workers.Go(func() { u.execute(r) })
+		return r, nil
+	}
+	if u.running.covers(ids) {
+		return u.running, nil
+	}
+	if u.pending == nil {
+		u.pending = newRun(ids)
+	} else {
+		u.pending.merge(ids)
+	}
+	return u.pending, nil
+}
+
+func (u *Updater) execute(r *run) {
+	u.update(r)
+
+	u.mu.Lock()
+	u.running = u.pending
+	u.pending = nil
+	if u.running != nil {
+		if u.stopped {
+			close(u.running.done)
+			u.running = nil
+		} else {
+			next := u.running
+			u.workers.Go(func() { u.execute(next) })
+		}
+	}
+	u.mu.Unlock()
+	close(r.done)
+}
+
+func (u *Updater) update(r *run) {
+	defer u.notify(r)
+	feeds, err := u.DB.AllFeeds()
+	if err != nil {
+		u.Logger.Print(err)
+	}
+
+	// Disabled and pushed feeds are only fetched if they are asked for.
+	todo := make([]db.Feed, 0)
+	ids := make(map[int]bool)
+	for _, feed := range feeds {
+		if (r.all && !feed.Disabled && !u.pushed(feed)) || r.ids[feed.ID] {
+			todo = append(todo, feed)
+			ids[feed.ID] = true
+		}
+	}
+	u.mu.Lock()
+	r.todo = ids
+	r.total = len(todo)
+	u.mu.Unlock()
+
+	for _, feed := range todo {
+		select {
+		case <-u.workers.Stopping():
+			return
+		default:
+		}
+
+		res := u.updateFeed(u.workers.Context(), feed)
+		u.mu.Lock()
+		r.results = append(r.results, res)
+		u.emit(res)
+		u.mu.Unlock()
+	}
+}
+
+// Listen returns a channel that receives the result of every feed
+// that is fetched or pushed, until stop is called. Results are dropped
+// while the listener is behind.
+func (u *Updater) Listen() (results <-chan Result, stop func()) {
+	c := make(chan Result, listenBuffer)
+	u.mu.Lock()
+	if u.listeners == nil {
+		u.listeners = make(map[chan Result]struct{})
+	}
+	u.listeners[c] = struct{}{}
+	u.mu.Unlock()
+	return c, func() {
+		u.mu.Lock()
+		delete(u.listeners, c)
+		u.mu.Unlock()
+	}
+}
+
+// emit passes res to the listeners. The caller must hold u.mu.
+func (u *Updater) emit(res Result) {
+	for c := range u.listeners {
+		select {
+		case c <- res:
+		default:
+		}
+	}
+}
+
+// pushed reports whether the hub of the feed pushes its items
+// and it was checked recently.
+func (u *Updater) pushed(feed db.Feed) bool {
+	return u.WebSub != nil && u.WebSub.Active(feed.ID) &&
+		feed.LastChecked.Valid && time.Since(feed.LastChecked.Time) < pushedInterval
+}
+
+// notify passes the new items of the pass to the Notifier.
+func (u *Updater) notify(r *run) {
+	if u.Notifier == nil {
+		return
+	}
+	events := make([]notify.Event, 0)
+	u.mu.Lock()
+	for _, res := range r.results {
+		if len(res.Items) > 0 && res.Feed.LastChecked.Valid {
+			events = append(events, notify.Event{Feed: res.Feed, Items: res.Items})
+		}
+	}
+	u.mu.Unlock()
+	if len(events) > 0 {
+		u.Notifier.Notify(events...)
+	}
+}
+
+func (u *Updater) updateFeed(ctx context.Context, feed db.Feed) Result {
+	res := Result{Feed: feed}
+	parsed, err := u.parse(ctx, feed)
+	if err != nil {
+		u.Logger.Printf("failed parsing feed %s, %v", feed.FeedURL, err)
+		res.Err = err
+		if err := u.DB.SetFeedError(feed.ID, err); err != nil {
+			u.Logger.Printf("failed updating db %v", err)
+		}
+		if parser.IsGone(err) {
+			if err := u.DB.SetFeedDisabled(feed.ID, true); err != nil {
+				u.Logger.Printf("failed updating db %v", err)
+			}
+		}
+		return res
+	}
+
+	for _, warning := range parsed.Warnings {
+		u.Logger.Printf("feed %s: %v", feed.FeedURL, warning)
+	}
+	if parsed.PermanentURL != "" {
+		if err := u.DB.SetFeedURL(feed.ID, parsed.PermanentURL); err != nil {
+			u.Logger.Printf("failed moving feed %s to %s, %v", feed.FeedURL, parsed.PermanentURL, err)
+		}
+	}
+	if err := u.DB.SetFeedDisabled(feed.ID, false); err != nil {
+		u.Logger.Printf("failed updating db %v", err)
+	}
+	if err := u.DB.SetFeedMeta(feed.ID, parsed.FeedMeta); err != nil {
+		u.Logger.Printf("failed updating db %v", err)
+	}
+	if err := u.DB.SetFeedSkipped(feed.ID, parsed.Skipped); err != nil {
+		u.Logger.Printf("failed updating db %v", err)
+	}
+	if u.WebSub != nil && feed.Options == "" {
+		topic := parsed.Self
+		if topic == "" {
+			topic = feed.FeedURL
+		}
+		u.WebSub.Want(feed.ID, parsed.Hub, topic)
+	}
+	return u.store(feed, parsed)
+}
+
+// store adds the items of the feed that are kept by the rules.
+func (u *Updater) store(feed db.Feed, parsed *parser.Feed) Result {
+	res := Result{Feed: feed}
+	items, err := u.applyRules(feed, parsed)
+	if err != nil {
+		u.Logger.Printf("failed loading rules %v", err)
+		res.Err = err
+		return res
+	}
+	res.Items, err = u.DB.AddNewItems(feed.ID, items)
+	res.NewItems = len(res.Items)
+	if err != nil {
+		u.Logger.Printf("failed updating db %v", err)
+		res.Err = err
+	}
+	return res
+}
+
+// Push stores the items of content that was pushed for the feed, like
+// a fetch would, without changing what is known about the feed itself.
+// It returns sql.ErrNoRows if the feed does not exist.
+func (u *Updater) Push(feedID int, data []byte, contentType string) (Result, error) {
+	u.init()
+	feed, err := u.DB.Feed(feedID)
+	if err != nil {
+		return Result{}, err
+	}
+	parsed, err := u.Parser.ParseBody(feed.FeedURL, data, contentType)
+	if err != nil {
+		u.Logger.Printf("failed parsing pushed content of feed %s, %v", feed.FeedURL, err)
+		return Result{Feed: feed, Err: err}, nil
+	}
+	res := u.store(feed, parsed)
+	u.mu.Lock()
+	u.emit(res)
+	u.mu.Unlock()
+	if u.Notifier != nil && len(res.Items) > 0 && feed.LastChecked.Valid {
+		u.Notifier.Notify(notify.Event{Feed: feed, Items: res.Items})
+	}
+	return res, nil
+}
+
+// applyRules returns the items of the feed that are kept by the rules.
+func (u *Updater) applyRules(feed db.Feed, parsed *parser.Feed) ([]db.Item, error) {
+	set, invalid, err := rules.Load(u.DB)
+	if err != nil {
+		return nil, err
+	}
+	for _, err := range invalid {
+		u.Logger.Printf("skipping invalid %v", err)
+	}
+	items, dropped := set.Apply(feed.ID, parsed, time.Now())
+	if dropped > 0 {
+		u.Logger.Printf("feed %s: %d items dropped by rules", feed.FeedURL, dropped)
+	}
+	return items, nil
+}
+
+// Fetch fetches the feed with its options without storing anything.
+func (u *Updater) Fetch(ctx context.Context, feed db.Feed) (*parser.Feed, error) {
+	u.init()
+	return u.parse(ctx, feed)
+}
+
+// parse fetches the feed with its options.
+func (u *Updater) parse(ctx context.Context, feed db.Feed) (*parser.Feed, error) {
+	var opts parser.Options
+	if feed.Options != "" {
+		if u.Secrets == nil {
+			return nil, errors.New("updater: the feed has fetch options, but no secret key is configured")
+		}
+		if err := u.Secrets.OpenJSON(feed.Options, &opts); err != nil {
+			return nil, err
+		}
+	}
+	return u.Parser.ParseWith(ctx, feed.FeedURL, opts)
+}
+
+func (u *Updater) compact() {
+	if _, err := u.DB.Compact(u.Retention); err != nil {
+		u.Logger.Printf("failed compacting db %v", err)
+	}
+}
+
+// pruneSubscriptions unsubscribes the feeds that were removed.
+func (u *Updater) pruneSubscriptions() {
+	if u.WebSub == nil {
+		return
+	}
+	feeds, err := u.DB.AllFeeds()
+	if err != nil {
+		u.Logger.Print(err)
+		return
+	}
+	ids := make(map[int]bool)
+	for _, feed := range feeds {
+		ids[feed.ID] = true
+	}
+	if err := u.WebSub.Prune(ids); err != nil {
+		u.Logger.Printf("failed removing subscriptions %v", err)
+	}
+}
+
+func (u *Updater) updateIcons() {
+	if u.Icons == nil {
+		return
+	}
+	feeds, err := u.DB.AllFeeds()
+	if err != nil {
+		u.Logger.Print(err)
+		return
+	}
+
+	ids := make(map[int]bool)
+	for _, feed := range feeds {
+		ids[feed.ID] = true
+	}
+	if err := u.Icons.Prune(ids); err != nil {
+		u.Logger.Printf("failed removing icons %v", err)
+	}
+
+	for _, feed := range feeds {
+		select {
+		case <-u.workers.Stopping():
+			return
+		default:
+		}
+		if !u.Icons.Stale(feed.ID) {
+			continue
+		}
+		ctx := u.workers.Context()
+		if err := u.Icons.Update(ctx, feed); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			u.Logger.Printf("failed storing icon of feed %s, %v", feed.FeedURL, err)
+		}
+	}
+}
+
+// Shutdown stops the background updates. A fetch that is already running
+// may finish and store its items, unless ctx expires first, in which case
+// the fetch is cancelled and ctx.Err() is returned.
+func (u *Updater) Shutdown(ctx context.Context) error {
+	u.mu.Lock()
+	u.stopped = true
+	u.mu.Unlock()
+	return u.workers.Shutdown(ctx)
+}
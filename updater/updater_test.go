@@ -0,0 +1,277 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/erikfastermann/feeder/db"
+	"github.com/erikfastermann/feeder/notify"
+	"github.com/erikfastermann/feeder/parser"
+)
+
+func TestRefreshCoalesces(t *testing.T) {
+	var mu sync.Mutex
+	fetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetches++
+		mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		fmt.Fprintf(w, `<rss><channel><item><title>t</title><link>http://example.com%s</link></item></channel></rss>`, r.URL.Path)
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir(os.TempDir(), "feeder-updater-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, err := db.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	const feeds = 3
+	for i := 0; i < feeds; i++ {
+		if _, err := d.AddFeed("host", fmt.Sprintf("%s/%d", srv.URL, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	u := &Updater{DB: d, Parser: &parser.Parser{Fetcher: srv.Client()}}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results, err := u.Refresh(context.Background())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if len(results) != feeds {
+				t.Errorf("got %d results, expected %d", len(results), feeds)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The first call starts a pass, the others are merged into the next one.
+	if fetches > 2*feeds {
+		t.Errorf("fetched %d times, expected at most %d", fetches, 2*feeds)
+	}
+
+	results, err := u.Refresh(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Feed.ID != 1 || results[0].Err != nil {
+		t.Fatalf("unexpected results %+v", results)
+	}
+
+	if err := u.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.Refresh(context.Background()); err != ErrStopped {
+		t.Fatalf("expected %v, got %v", ErrStopped, err)
+	}
+}
+
+func TestRefreshSkipped(t *testing.T) {
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`<rss><channel><item><link>http://example.com/1</link></item></channel></rss>`))
+	})
+	mux.HandleFunc("/added", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<rss><channel><item><link>http://example.com/1</link></item></channel></rss>`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir(os.TempDir(), "feeder-updater-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, err := db.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if _, err := d.AddFeed("host", srv.URL+"/slow"); err != nil {
+		t.Fatal(err)
+	}
+
+	u := &Updater{DB: d, Parser: &parser.Parser{Fetcher: srv.Client()}}
+	defer u.Shutdown(context.Background())
+	all := make(chan error, 1)
+	go func() {
+		_, err := u.Refresh(context.Background())
+		all <- err
+	}()
+	for {
+		if running, _, total := u.Progress(); running && total == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The running pass started before the feed was added,
+	// so it is fetched after it.
+	id, err := d.AddFeed("host", srv.URL+"/added")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(50*time.Millisecond, func() { close(release) })
+	results, err := u.Refresh(context.Background(), id)
+	if err != nil || len(results) != 1 || results[0].Feed.ID != id || results[0].Err != nil {
+		t.Fatalf("unexpected results %+v, %v", results, err)
+	}
+	if err := <-all; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMovedAndGone(t *testing.T) {
+	gone := false
+	mux := http.NewServeMux()
+	mux.Handle("/old", http.RedirectHandler("/new", http.StatusMovedPermanently))
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		if gone {
+			http.Error(w, "gone", http.StatusGone)
+			return
+		}
+		w.Write([]byte(`<rss><channel><item><link>http://example.com/1</link></item></channel></rss>`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir(os.TempDir(), "feeder-updater-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, err := db.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	id, err := d.AddFeed(srv.URL, srv.URL+"/old")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := &Updater{DB: d, Parser: &parser.Parser{Fetcher: srv.Client()}}
+	defer u.Shutdown(context.Background())
+	if _, err := u.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	feed, err := d.Feed(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feed.FeedURL != srv.URL+"/new" {
+		t.Fatalf("got feed URL %s, want %s", feed.FeedURL, srv.URL+"/new")
+	}
+
+	gone = true
+	if _, err := u.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if feed, err = d.Feed(id); err != nil || !feed.Disabled {
+		t.Fatalf("feed not disabled after 410, %v", err)
+	}
+	results, err := u.Refresh(context.Background())
+	if err != nil || len(results) != 0 {
+		t.Fatalf("disabled feed fetched, %v", err)
+	}
+
+	gone = false
+	results, err = u.Refresh(context.Background(), id)
+	if err != nil || len(results) != 1 || results[0].Err != nil {
+		t.Fatalf("unexpected results %+v, %v", results, err)
+	}
+	if feed, err = d.Feed(id); err != nil || feed.Disabled {
+		t.Fatalf("feed still disabled after refresh, %v", err)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	var mu sync.Mutex
+	items := 1
+	var notified []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/feed", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprint(w, "<rss><channel>")
+		for i := 0; i < items; i++ {
+			fmt.Fprintf(w, "<item><title>%d</title><link>http://example.com/%d</link></item>", i, i)
+		}
+		fmt.Fprint(w, "</channel></rss>")
+	})
+	mux.HandleFunc("/hook", func(w http.ResponseWriter, r *http.Request) {
+		var payload struct{ Text string }
+		json.NewDecoder(r.Body).Decode(&payload)
+		mu.Lock()
+		notified = append(notified, payload.Text)
+		mu.Unlock()
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir(os.TempDir(), "feeder-updater-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, err := db.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if _, err := d.AddFeed("host", srv.URL+"/feed"); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := notify.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Targets = []notify.Target{{Name: "slack", Type: notify.TypeSlack, URL: srv.URL + "/hook"}}
+	n.Start()
+	u := &Updater{DB: d, Parser: &parser.Parser{Fetcher: srv.Client()}, Notifier: n}
+	defer u.Shutdown(context.Background())
+
+	// The items of the first fetch are not new to the user.
+	if _, err := u.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	items = 3
+	mu.Unlock()
+	if _, err := u.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(notified) != 1 || strings.Count(notified[0], "\n") != 1 || !strings.Contains(notified[0], "example.com/2") {
+		t.Fatalf("got notifications %q", notified)
+	}
+}
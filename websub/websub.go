@@ -0,0 +1,647 @@
+// Package websub subscribes to the WebSub hubs of feeds, so that new
+// items are pushed instead of waiting for the next poll,
+// see https://www.w3.org/TR/websub/.
+package websub
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/erikfastermann/feeder/internal/flock"
+	"github.com/erikfastermann/feeder/internal/worker"
+)
+
+const (
+	// SubsFile in the directory passed to Open keeps the subscriptions.
+	SubsFile = "websub.csv"
+
+	// DefaultLease is asked for when subscribing, hubs may grant another.
+	DefaultLease = 10 * 24 * time.Hour
+	// DefaultRetry is how long to wait after a hub failed
+	// before subscribing again. The feed is polled meanwhile.
+	DefaultRetry   = 6 * time.Hour
+	DefaultTimeout = 10 * time.Second
+
+	// HeaderSignature holds the signature of the pushed content.
+	HeaderSignature = "X-Hub-Signature"
+
+	// verifyTimeout is how long a hub may take to verify a request.
+	verifyTimeout = time.Hour
+	checkInterval = time.Minute
+)
+
+// States of a subscription.
+const (
+	// StateNew subscriptions are requested as soon as possible.
+	StateNew = "new"
+	// StatePending subscriptions wait for the hub to verify them.
+	StatePending = "pending"
+	// StateActive subscriptions receive content until their lease expires.
+	StateActive = "active"
+	// StateFailed subscriptions were denied or the hub failed,
+	// they are tried again after Retry.
+	StateFailed = "failed"
+	// StateUnsubscribe subscriptions are cancelled at the hub
+	// and removed once it verified that.
+	StateUnsubscribe = "unsubscribe"
+)
+
+var (
+	// ErrUnknown is returned for callbacks of feeds without a subscription.
+	ErrUnknown = errors.New("websub: no subscription for the feed")
+	// ErrSignature is returned for pushed content without a valid signature.
+	ErrSignature = errors.New("websub: invalid signature")
+)
+
+var timeNow = time.Now
+
+// Subscription is the subscription of a feed to its hub.
+type Subscription struct {
+	FeedID int
+	Hub    string
+	Topic  string
+	// Secret keys the signatures of the pushed content.
+	Secret string
+	State  string
+	// Attempt is the time of the last request to the hub.
+	Attempt time.Time
+	// Lease is what the hub granted, active subscriptions expire
+	// at Expires and are renewed when a quarter of it is left.
+	Lease   time.Duration
+	Expires time.Time
+	// Err is the error of the last request to the hub, if it failed.
+	Err string
+}
+
+// Active reports whether the hub currently pushes the content of the feed.
+func (s Subscription) Active() bool {
+	return s.State == StateActive && timeNow().Before(s.Expires)
+}
+
+// due reports whether a request has to be sent to the hub.
+func (s Subscription) due(now time.Time, retry time.Duration) bool {
+	switch s.State {
+	case StateNew:
+		return true
+	case StateActive:
+		// A renewal that was not verified is sent again after verifyTimeout.
+		renew := s.Expires.Add(-s.Lease / 4)
+		return !now.Before(renew) && (s.Attempt.Before(renew) || now.Sub(s.Attempt) >= verifyTimeout)
+	case StateFailed:
+		return now.Sub(s.Attempt) >= retry
+	case StateUnsubscribe:
+		return s.Attempt.IsZero()
+	}
+	return false
+}
+
+const (
+	sFeedID  = 0
+	sHub     = 1
+	sTopic   = 2
+	sSecret  = 3
+	sState   = 4
+	sAttempt = 5
+	sLease   = 6
+	sExpires = 7
+	sErr     = 8
+	sLen     = 9
+)
+
+// Subscriber keeps the subscriptions of the feeds and renews them
+// in the background. The callbacks must be routed to Verify and Receive.
+//
+// Processes sharing the directory, like a worker and a server, may each
+// open a Subscriber. The file is locked while it is changed, the process
+// that is started sends the requests and answers the callbacks.
+type Subscriber struct {
+	Logger *log.Logger
+	// Callback is the public URL the callbacks are served under,
+	// the id of the feed is appended to it.
+	Callback string
+	Client   *http.Client
+	// Lease is asked for when subscribing.
+	Lease time.Duration
+	// Retry is how long to wait after a hub failed.
+	Retry time.Duration
+
+	file *os.File
+
+	initOnce sync.Once
+	kick     chan struct{}
+	workers  worker.Group
+
+	mu   sync.Mutex
+	subs map[int]*Subscription
+}
+
+// Open returns a Subscriber that keeps its subscriptions in dir.
+func Open(dir string) (*Subscriber, error) {
+	f, err := os.OpenFile(filepath.Join(dir, SubsFile), os.O_RDWR|os.O_CREATE|os.O_SYNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &Subscriber{file: f, subs: make(map[int]*Subscription)}
+	if err := s.Reload(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload loads the subscriptions changed by other processes sharing the file.
+func (s *Subscriber) Reload() error {
+	if err := s.lock(); err != nil {
+		return err
+	}
+	s.unlock()
+	return nil
+}
+
+// lock locks the subscriptions, also for other processes sharing
+// the file, and loads them.
+func (s *Subscriber) lock() error {
+	s.mu.Lock()
+	if err := flock.Lock(s.file); err != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("websub: %s: %v", SubsFile, err)
+	}
+	if err := s.load(); err != nil {
+		flock.Unlock(s.file)
+		s.mu.Unlock()
+		return fmt.Errorf("websub: %s: %v", SubsFile, err)
+	}
+	return nil
+}
+
+func (s *Subscriber) unlock() {
+	flock.Unlock(s.file)
+	s.mu.Unlock()
+}
+
+// load replaces the subscriptions with the file. The caller must hold the lock.
+func (s *Subscriber) load() error {
+	date := func(str string) (time.Time, error) {
+		if str == "" {
+			return time.Time{}, nil
+		}
+		return time.Parse(time.RFC3339, str)
+	}
+
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	recs, err := csv.NewReader(s.file).ReadAll()
+	if err != nil {
+		return err
+	}
+	subs := make(map[int]*Subscription, len(recs))
+	for _, r := range recs {
+		if len(r) != sLen {
+			return errors.New("unexpected row length")
+		}
+		sub := &Subscription{
+			Hub:    r[sHub],
+			Topic:  r[sTopic],
+			Secret: r[sSecret],
+			State:  r[sState],
+			Err:    r[sErr],
+		}
+		if sub.FeedID, err = strconv.Atoi(r[sFeedID]); err != nil {
+			return err
+		}
+		if sub.Attempt, err = date(r[sAttempt]); err != nil {
+			return err
+		}
+		if sub.Lease, err = time.ParseDuration(r[sLease]); err != nil {
+			return err
+		}
+		if sub.Expires, err = date(r[sExpires]); err != nil {
+			return err
+		}
+		subs[sub.FeedID] = sub
+	}
+	s.subs = subs
+	return nil
+}
+
+// save replaces the file with the subscriptions. The caller must hold the lock.
+func (s *Subscriber) save() error {
+	date := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+
+	recs := make([][]string, 0, len(s.subs))
+	for _, sub := range s.sorted() {
+		r := make([]string, sLen)
+		r[sFeedID] = strconv.Itoa(sub.FeedID)
+		r[sHub] = sub.Hub
+		r[sTopic] = sub.Topic
+		r[sSecret] = sub.Secret
+		r[sState] = sub.State
+		r[sAttempt] = date(sub.Attempt)
+		r[sLease] = sub.Lease.String()
+		r[sExpires] = date(sub.Expires)
+		r[sErr] = sub.Err
+		recs = append(recs, r)
+	}
+	if err := s.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return csv.NewWriter(s.file).WriteAll(recs)
+}
+
+func (s *Subscriber) sorted() []Subscription {
+	subs := make([]Subscription, 0, len(s.subs))
+	for _, sub := range s.subs {
+		subs = append(subs, *sub)
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].FeedID < subs[j].FeedID
+	})
+	return subs
+}
+
+// persist saves the subscriptions and logs failures. The caller must hold the lock.
+func (s *Subscriber) persist() {
+	if err := s.save(); err != nil {
+		s.Logger.Printf("failed writing the subscriptions %v", err)
+	}
+}
+
+func (s *Subscriber) init() {
+	s.initOnce.Do(func() {
+		if s.Logger == nil {
+			s.Logger = log.New(ioutil.Discard, "", 0)
+		}
+		if s.Client == nil {
+			s.Client = &http.Client{Timeout: DefaultTimeout}
+		}
+		if s.Lease <= 0 {
+			s.Lease = DefaultLease
+		}
+		if s.Retry <= 0 {
+			s.Retry = DefaultRetry
+		}
+		s.kick = make(chan struct{}, 1)
+	})
+}
+
+// Subscriptions returns all subscriptions ordered by feed id,
+// as they were last loaded.
+func (s *Subscriber) Subscriptions() []Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sorted()
+}
+
+// Active reports whether the hub of the feed currently pushes its content.
+func (s *Subscriber) Active(feedID int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subs[feedID]
+	return ok && sub.Active()
+}
+
+// Want subscribes the feed to topic at hub, unless it already is.
+// An empty hub unsubscribes the feed.
+func (s *Subscriber) Want(feedID int, hub, topic string) {
+	s.init()
+	if err := s.lock(); err != nil {
+		s.Logger.Print(err)
+		return
+	}
+	defer s.unlock()
+
+	sub, ok := s.subs[feedID]
+	switch {
+	case hub == "" && (!ok || sub.State == StateUnsubscribe):
+		return
+	case hub == "":
+		s.unsubscribe(sub)
+	case ok && sub.Hub == hub && sub.Topic == topic && sub.State != StateUnsubscribe:
+		return
+	default:
+		u, err := url.Parse(hub)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			s.Logger.Printf("feed %d: ignoring invalid hub %q", feedID, hub)
+			return
+		}
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			s.Logger.Printf("feed %d: %v", feedID, err)
+			return
+		}
+		s.subs[feedID] = &Subscription{
+			FeedID: feedID,
+			Hub:    hub,
+			Topic:  topic,
+			Secret: hex.EncodeToString(secret),
+			State:  StateNew,
+		}
+	}
+	s.persist()
+	s.wake()
+}
+
+// unsubscribe marks sub to be cancelled. The caller must hold the lock.
+func (s *Subscriber) unsubscribe(sub *Subscription) {
+	if sub.State == StateActive || sub.State == StatePending {
+		sub.State = StateUnsubscribe
+		sub.Attempt = time.Time{}
+		sub.Err = ""
+		return
+	}
+	// The hub does not push anything without a subscription.
+	delete(s.subs, sub.FeedID)
+}
+
+// Prune unsubscribes the feeds that are not in ids.
+func (s *Subscriber) Prune(ids map[int]bool) error {
+	s.init()
+	if err := s.lock(); err != nil {
+		return err
+	}
+	defer s.unlock()
+
+	changed := false
+	for id, sub := range s.subs {
+		if !ids[id] && sub.State != StateUnsubscribe {
+			s.unsubscribe(sub)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	s.wake()
+	return s.save()
+}
+
+func (s *Subscriber) wake() {
+	select {
+	case s.kick <- struct{}{}:
+	default:
+	}
+}
+
+// Start sends the requests to the hubs until Shutdown is called.
+func (s *Subscriber) Start() {
+	s.init()
+	s.workers.Go(s.loop)
+}
+
+func (s *Subscriber) loop() {
+	t := time.NewTicker(checkInterval)
+	defer t.Stop()
+	for {
+		s.maintain(s.workers.Context())
+		select {
+		case <-s.workers.Stopping():
+			return
+		case <-t.C:
+		case <-s.kick:
+		}
+	}
+}
+
+// maintain subscribes, renews and unsubscribes the feeds that are due
+// and gives up on requests the hubs did not verify in time.
+func (s *Subscriber) maintain(ctx context.Context) {
+	s.init()
+	now := timeNow()
+	if err := s.lock(); err != nil {
+		s.Logger.Print(err)
+		return
+	}
+	todo := make([]Subscription, 0)
+	changed := false
+	for id, sub := range s.subs {
+		if !sub.Attempt.IsZero() && now.Sub(sub.Attempt) >= verifyTimeout {
+			switch sub.State {
+			case StatePending:
+				sub.State = StateFailed
+				sub.Err = "the hub did not verify the subscription"
+				changed = true
+			case StateUnsubscribe:
+				delete(s.subs, id)
+				changed = true
+				continue
+			}
+		}
+		if sub.State == StateActive && !now.Before(sub.Expires) {
+			sub.State = StateFailed
+			if sub.Err == "" {
+				sub.Err = "the lease expired"
+			}
+			changed = true
+		}
+		if !sub.due(now, s.Retry) {
+			continue
+		}
+		// Hubs may verify before they answer the request.
+		sub.Attempt = now
+		if sub.State == StateNew || sub.State == StateFailed {
+			sub.State = StatePending
+		}
+		changed = true
+		todo = append(todo, *sub)
+	}
+	if changed {
+		s.persist()
+	}
+	s.unlock()
+
+	for _, sub := range todo {
+		select {
+		case <-s.workers.Stopping():
+			return
+		default:
+		}
+		mode := "subscribe"
+		if sub.State == StateUnsubscribe {
+			mode = "unsubscribe"
+		}
+		err := s.request(ctx, sub, mode)
+		if err == nil {
+			continue
+		}
+		s.Logger.Printf("feed %d: failed to %s at %s, %v", sub.FeedID, mode, sub.Hub, err)
+
+		if err := s.lock(); err != nil {
+			s.Logger.Print(err)
+			continue
+		}
+		if cur, ok := s.subs[sub.FeedID]; ok && cur.Hub == sub.Hub && cur.Topic == sub.Topic {
+			switch cur.State {
+			case StatePending:
+				cur.State = StateFailed
+			case StateUnsubscribe:
+				// The lease expires on its own.
+				delete(s.subs, cur.FeedID)
+			}
+			cur.Err = err.Error()
+			s.persist()
+		}
+		s.unlock()
+	}
+}
+
+// request sends a subscription request to the hub of sub.
+func (s *Subscriber) request(ctx context.Context, sub Subscription, mode string) error {
+	form := url.Values{
+		"hub.callback": {s.Callback + strconv.Itoa(sub.FeedID)},
+		"hub.mode":     {mode},
+		"hub.topic":    {sub.Topic},
+	}
+	if mode == "subscribe" {
+		form.Set("hub.lease_seconds", strconv.Itoa(int(s.Lease/time.Second)))
+		form.Set("hub.secret", sub.Secret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Hub, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		msg := strings.TrimSpace(string(body))
+		if msg == "" {
+			msg = http.StatusText(res.StatusCode)
+		}
+		return fmt.Errorf("websub: the hub responded with %d, %s", res.StatusCode, msg)
+	}
+	return nil
+}
+
+// Verify answers the verification of a request by the hub with the
+// query parameters of the callback. It returns the challenge the hub
+// expects in the response, or an error if the feed did not ask for it.
+func (s *Subscriber) Verify(feedID int, query url.Values) (string, error) {
+	s.init()
+	if err := s.lock(); err != nil {
+		return "", err
+	}
+	defer s.unlock()
+
+	sub, ok := s.subs[feedID]
+	if !ok || query.Get("hub.topic") != sub.Topic {
+		return "", ErrUnknown
+	}
+	mode, challenge := query.Get("hub.mode"), query.Get("hub.challenge")
+	switch {
+	case mode == "subscribe" && (sub.State == StatePending || sub.State == StateActive):
+		if challenge == "" {
+			return "", errors.New("websub: verification without a challenge")
+		}
+		sub.Lease = s.Lease
+		if secs, err := strconv.Atoi(query.Get("hub.lease_seconds")); err == nil && secs > 0 {
+			sub.Lease = time.Duration(secs) * time.Second
+		}
+		sub.State = StateActive
+		sub.Expires = timeNow().Add(sub.Lease)
+		sub.Err = ""
+	case mode == "unsubscribe" && sub.State == StateUnsubscribe:
+		if challenge == "" {
+			return "", errors.New("websub: verification without a challenge")
+		}
+		delete(s.subs, feedID)
+	case mode == "denied" && sub.State != StateUnsubscribe:
+		sub.State = StateFailed
+		sub.Err = "denied by the hub"
+		if reason := query.Get("hub.reason"); reason != "" {
+			sub.Err += ", " + reason
+		}
+	default:
+		return "", fmt.Errorf("websub: unexpected %s of a subscription that is %s", mode, sub.State)
+	}
+	s.persist()
+	return challenge, nil
+}
+
+// Receive checks the signature of content pushed for the feed,
+// given as the value of the HeaderSignature header.
+func (s *Subscriber) Receive(feedID int, body []byte, signature string) error {
+	if err := s.lock(); err != nil {
+		return err
+	}
+	sub, ok := s.subs[feedID]
+	var secret string
+	if ok && sub.State != StateUnsubscribe {
+		secret = sub.Secret
+	}
+	s.unlock()
+	if secret == "" {
+		return ErrUnknown
+	}
+
+	method, sig, ok := strings.Cut(signature, "=")
+	if !ok {
+		return ErrSignature
+	}
+	var h func() hash.Hash
+	switch method {
+	case "sha1":
+		h = sha1.New
+	case "sha256":
+		h = sha256.New
+	case "sha384":
+		h = sha512.New384
+	case "sha512":
+		h = sha512.New
+	default:
+		return ErrSignature
+	}
+	want, err := hex.DecodeString(sig)
+	if err != nil {
+		return ErrSignature
+	}
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), want) {
+		return ErrSignature
+	}
+	return nil
+}
+
+// Shutdown stops the requests to the hubs, cancelling a running one
+// if ctx expires first. The subscriptions stay active and the file is closed.
+func (s *Subscriber) Shutdown(ctx context.Context) error {
+	err := s.workers.Shutdown(ctx)
+	s.mu.Lock()
+	s.file.Close()
+	s.mu.Unlock()
+	return err
+}
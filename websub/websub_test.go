@@ -0,0 +1,309 @@
+package websub
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeHub verifies requests synchronously, like most hubs do.
+type fakeHub struct {
+	t *testing.T
+	// mode is how the hub answers: ok, fail, deny or silent,
+	// which accepts the request without verifying it.
+	mode     string
+	lease    int
+	mu       sync.Mutex
+	requests []url.Values
+}
+
+func (h *fakeHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.t.Error(err)
+		return
+	}
+	h.mu.Lock()
+	h.requests = append(h.requests, r.PostForm)
+	mode := h.mode
+	h.mu.Unlock()
+
+	q := url.Values{"hub.topic": {r.PostForm.Get("hub.topic")}}
+	switch mode {
+	case "fail":
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	case "silent":
+		w.WriteHeader(http.StatusAccepted)
+		return
+	case "deny":
+		q.Set("hub.mode", "denied")
+		q.Set("hub.reason", "no")
+	default:
+		q.Set("hub.mode", r.PostForm.Get("hub.mode"))
+		q.Set("hub.challenge", "challenge")
+		q.Set("hub.lease_seconds", strconv.Itoa(h.lease))
+	}
+	res, err := http.Get(r.PostForm.Get("hub.callback") + "?" + q.Encode())
+	if err != nil {
+		h.t.Error(err)
+		return
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if mode == "ok" && (res.StatusCode != http.StatusOK || string(body) != "challenge") {
+		h.t.Errorf("verification returned %d %q", res.StatusCode, body)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *fakeHub) set(mode string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.mode = mode
+}
+
+func (h *fakeHub) last() (int, url.Values) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.requests) == 0 {
+		return 0, nil
+	}
+	return len(h.requests), h.requests[len(h.requests)-1]
+}
+
+// newTestSubscriber returns a Subscriber with a callback server
+// and a hub that grants leases of an hour.
+func newTestSubscriber(t *testing.T, dir string) (*Subscriber, *fakeHub, string) {
+	var s *Subscriber
+	cb := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		challenge, err := s.Verify(id, r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(challenge))
+	}))
+	t.Cleanup(cb.Close)
+	hub := &fakeHub{t: t, mode: "ok", lease: 3600}
+	hubSrv := httptest.NewServer(hub)
+	t.Cleanup(hubSrv.Close)
+
+	var err error
+	s, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Callback = cb.URL + "/"
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+	return s, hub, hubSrv.URL
+}
+
+func sign(h func() hash.Hash, method, secret, body string) string {
+	mac := hmac.New(h, []byte(secret))
+	mac.Write([]byte(body))
+	return method + "=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestSubscribe(t *testing.T) {
+	start := time.Now().Truncate(time.Second)
+	now := start
+	defer func(f func() time.Time) { timeNow = f }(timeNow)
+	timeNow = func() time.Time { return now }
+	dir := t.TempDir()
+	s, hub, hubURL := newTestSubscriber(t, dir)
+	const topic = "https://example.com/feed"
+	ctx := context.Background()
+
+	s.Want(1, hubURL, topic)
+	s.maintain(ctx)
+	n, form := hub.last()
+	if n != 1 || form.Get("hub.mode") != "subscribe" || form.Get("hub.topic") != topic ||
+		!strings.HasSuffix(form.Get("hub.callback"), "/1") ||
+		form.Get("hub.lease_seconds") != strconv.Itoa(int(DefaultLease/time.Second)) {
+		t.Fatalf("got %d requests, the last %v", n, form)
+	}
+	if !s.Active(1) || s.Active(2) {
+		t.Fatalf("got subscriptions %+v", s.Subscriptions())
+	}
+	sub := s.Subscriptions()[0]
+	if sub.Lease != time.Hour || !sub.Expires.Equal(start.Add(time.Hour)) || sub.Secret != form.Get("hub.secret") {
+		t.Errorf("got subscription %+v", sub)
+	}
+
+	const body = "<feed/>"
+	if err := s.Receive(1, []byte(body), sign(sha256.New, "sha256", sub.Secret, body)); err != nil {
+		t.Errorf("sha256: %v", err)
+	}
+	if err := s.Receive(1, []byte(body), sign(sha1.New, "sha1", sub.Secret, body)); err != nil {
+		t.Errorf("sha1: %v", err)
+	}
+	for _, sig := range []string{"", "sha256=00", sign(sha256.New, "sha256", "wrong", body), sign(sha256.New, "md5", sub.Secret, body)} {
+		if err := s.Receive(1, []byte(body), sig); err != ErrSignature {
+			t.Errorf("signature %q: got %v, want %v", sig, err, ErrSignature)
+		}
+	}
+	if err := s.Receive(2, []byte(body), ""); err != ErrUnknown {
+		t.Errorf("got %v, want %v", err, ErrUnknown)
+	}
+	if _, err := s.Verify(1, url.Values{"hub.mode": {"subscribe"}, "hub.topic": {"other"}, "hub.challenge": {"x"}}); err == nil {
+		t.Error("verified another topic")
+	}
+
+	// Nothing is due until a quarter of the lease is left.
+	s.Want(1, hubURL, topic)
+	now = start.Add(40 * time.Minute)
+	s.maintain(ctx)
+	if n, _ := hub.last(); n != 1 {
+		t.Fatalf("got %d requests before the renewal", n)
+	}
+	now = start.Add(50 * time.Minute)
+	s.maintain(ctx)
+	if n, _ := hub.last(); n != 2 {
+		t.Fatalf("got %d requests, want the renewal", n)
+	}
+	if sub := s.Subscriptions()[0]; !sub.Expires.Equal(start.Add(110 * time.Minute)) {
+		t.Errorf("got expiry %v after the renewal", sub.Expires)
+	}
+
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s, _, _ = newTestSubscriber(t, dir)
+	if got := s.Subscriptions(); len(got) != 1 || !got[0].Active() || got[0].Secret != sub.Secret {
+		t.Errorf("got %+v after reopening", got)
+	}
+}
+
+func TestHubFailure(t *testing.T) {
+	start := time.Now()
+	now := start
+	defer func(f func() time.Time) { timeNow = f }(timeNow)
+	timeNow = func() time.Time { return now }
+	s, hub, hubURL := newTestSubscriber(t, t.TempDir())
+	ctx := context.Background()
+
+	hub.set("fail")
+	s.Want(1, hubURL, "https://example.com/feed")
+	s.maintain(ctx)
+	if sub := s.Subscriptions()[0]; sub.State != StateFailed || !strings.Contains(sub.Err, "503") || s.Active(1) {
+		t.Fatalf("got %+v", sub)
+	}
+	s.maintain(ctx)
+	if n, _ := hub.last(); n != 1 {
+		t.Fatalf("got %d requests before the retry", n)
+	}
+
+	hub.set("deny")
+	now = start.Add(DefaultRetry)
+	s.maintain(ctx)
+	if sub := s.Subscriptions()[0]; sub.State != StateFailed || sub.Err != "denied by the hub, no" {
+		t.Fatalf("got %+v", sub)
+	}
+
+	hub.set("silent")
+	now = start.Add(2 * DefaultRetry)
+	s.maintain(ctx)
+	if sub := s.Subscriptions()[0]; sub.State != StatePending {
+		t.Fatalf("got %+v", sub)
+	}
+	now = start.Add(2*DefaultRetry + verifyTimeout)
+	s.maintain(ctx)
+	if sub := s.Subscriptions()[0]; sub.State != StateFailed || s.Active(1) {
+		t.Fatalf("got %+v", sub)
+	}
+
+	hub.set("ok")
+	now = start.Add(3*DefaultRetry + verifyTimeout)
+	s.maintain(ctx)
+	if !s.Active(1) {
+		t.Fatalf("got %+v", s.Subscriptions())
+	}
+	// The lease runs out if the hub fails to renew it.
+	hub.set("fail")
+	now = start.Add(3*DefaultRetry + verifyTimeout + time.Hour)
+	s.maintain(ctx)
+	if sub := s.Subscriptions()[0]; sub.State != StateFailed || s.Active(1) {
+		t.Fatalf("got %+v", sub)
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	s, hub, hubURL := newTestSubscriber(t, t.TempDir())
+	ctx := context.Background()
+
+	s.Want(1, hubURL, "https://example.com/1")
+	s.Want(2, hubURL, "https://example.com/2")
+	s.maintain(ctx)
+	if !s.Active(1) || !s.Active(2) {
+		t.Fatalf("got %+v", s.Subscriptions())
+	}
+
+	if err := s.Prune(map[int]bool{2: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Receive(1, nil, ""); err != ErrUnknown {
+		t.Errorf("got %v for a cancelled subscription, want %v", err, ErrUnknown)
+	}
+	s.maintain(ctx)
+	if _, form := hub.last(); form.Get("hub.mode") != "unsubscribe" || form.Get("hub.topic") != "https://example.com/1" {
+		t.Errorf("got request %v", form)
+	}
+
+	// The feed no longer advertises the hub.
+	s.Want(2, "", "")
+	s.maintain(ctx)
+	if got := s.Subscriptions(); len(got) != 0 {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestShared(t *testing.T) {
+	dir := t.TempDir()
+	s, hub, hubURL := newTestSubscriber(t, dir)
+	// A worker sharing the directory only subscribes and prunes the feeds.
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Shutdown(context.Background())
+	ctx := context.Background()
+
+	w.Want(1, hubURL, "https://example.com/1")
+	s.maintain(ctx)
+	if n, form := hub.last(); n != 1 || form.Get("hub.mode") != "subscribe" {
+		t.Fatalf("got %d requests, the last %v", n, form)
+	}
+	if w.Active(1) {
+		t.Fatal("active before reloading")
+	}
+	if err := w.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if !w.Active(1) {
+		t.Fatalf("got %+v after reloading", w.Subscriptions())
+	}
+
+	// Both change the file, neither loses the other's changes.
+	w.Want(2, hubURL, "https://example.com/2")
+	s.Want(3, hubURL, "https://example.com/3")
+	if err := w.Prune(map[int]bool{2: true, 3: true}); err != nil {
+		t.Fatal(err)
+	}
+	s.maintain(ctx)
+	if got := s.Subscriptions(); len(got) != 2 || !got[0].Active() || got[0].FeedID != 2 || !got[1].Active() {
+		t.Errorf("got %+v", got)
+	}
+}